@@ -0,0 +1,31 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// requireAdmin guards operator endpoints behind the QCHAT_ADMIN_TOKEN bearer
+// token. Without a configured token they are only reachable in development.
+func requireAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if adminToken == "" {
+			if !mode.DebugEnabled() {
+				abortWithError(c, http.StatusNotFound, "Not found")
+				return
+			}
+			c.Next()
+			return
+		}
+
+		token, _ := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			abortWithError(c, http.StatusUnauthorized, "Invalid or missing admin token")
+			return
+		}
+		c.Next()
+	}
+}
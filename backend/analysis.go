@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// InfoAnalysis is the information-theoretic view of a measured QBER
+type InfoAnalysis struct {
+	Qber              float64 `json:"qber"`
+	BinaryEntropy     float64 `json:"binaryEntropy"`     // H(QBER)
+	MutualInformation float64 `json:"mutualInformation"` // I(A:B) = 1 - H(QBER)
+	EveBobBound       float64 `json:"eveBobBound"`       // Upper bound on I(E:B) = H(QBER)
+	SecretKeyRate     float64 `json:"secretKeyRate"`     // I(A:B) - I(E:B), per sifted bit
+	PositiveKeyRate   bool    `json:"positiveKeyRate"`
+}
+
+// AnalyzeQBER computes the asymptotic BB84 information quantities for a QBER
+func AnalyzeQBER(qber float64) InfoAnalysis {
+	h := binaryEntropy(qber)
+	rate := 1 - 2*h
+	return InfoAnalysis{
+		Qber:              qber,
+		BinaryEntropy:     h,
+		MutualInformation: 1 - h,
+		EveBobBound:       h,
+		SecretKeyRate:     rate,
+		PositiveKeyRate:   rate > 0,
+	}
+}
+
+// Return the information analysis for a session's measured QBER
+func infoAnalysisHandler(c *gin.Context) {
+	session, ok := sessionFromRequest(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"sessionId": session.ID, "analysis": AnalyzeQBER(session.Result.Qber)})
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Audit event types
+const (
+	AuditKeyGenerated   = "key_generated"
+	AuditOffsetAdvanced = "offset_advanced"
+	AuditRekey          = "rekey"
+	AuditRangeUsed      = "range_used"
+	AuditKeyImported    = "key_imported"
+	AuditKeyExported    = "key_exported"
+	AuditKeyAppended    = "key_appended"
+
+	AuditSessionQuarantined = "session_quarantined"
+)
+
+// AuditEvent is a single audit log entry. It never carries key bytes,
+// only metadata about how key material was produced and consumed.
+type AuditEvent struct {
+	Time    time.Time `json:"time"`
+	Type    string    `json:"type"`
+	KeyBits int       `json:"keyBits,omitempty"`
+	Bytes   int       `json:"bytes,omitempty"`
+	Offset  int       `json:"offset,omitempty"`
+	Sender  string    `json:"sender,omitempty"`
+}
+
+// AuditLogger records key-material lifecycle events
+type AuditLogger interface {
+	Record(event AuditEvent) error
+}
+
+// maxSessionAuditEvents bounds the audit events each session keeps in memory
+const maxSessionAuditEvents = 1024
+
+// sessionAuditLog keeps the most recent audit events of one session for
+// forensic dumps and forwards every event to the process-wide logger, whose
+// entries do not say which session they belong to
+type sessionAuditLog struct {
+	next AuditLogger
+
+	mu     sync.Mutex
+	events []AuditEvent
+}
+
+func newSessionAuditLog(next AuditLogger) *sessionAuditLog {
+	return &sessionAuditLog{next: next}
+}
+
+func (l *sessionAuditLog) Record(event AuditEvent) error {
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+
+	l.mu.Lock()
+	if len(l.events) == maxSessionAuditEvents {
+		l.events = append(l.events[:0], l.events[1:]...)
+	}
+	l.events = append(l.events, event)
+	l.mu.Unlock()
+	return l.next.Record(event)
+}
+
+// Events returns the retained events, oldest first
+func (l *sessionAuditLog) Events() []AuditEvent {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]AuditEvent(nil), l.events...)
+}
+
+// nopAuditLogger discards all events; used when no audit log is configured
+type nopAuditLogger struct{}
+
+func (nopAuditLogger) Record(AuditEvent) error { return nil }
+
+// FileAuditLogger appends audit events as JSON lines to a file
+type FileAuditLogger struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileAuditLogger opens (or creates) an append-only audit log at path
+func NewFileAuditLogger(path string) (*FileAuditLogger, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %v", err)
+	}
+	return &FileAuditLogger{file: f}, nil
+}
+
+// Record writes a single event to the log
+func (l *FileAuditLogger) Record(event AuditEvent) error {
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+	line, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit event: %v", err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit event: %v", err)
+	}
+	return nil
+}
+
+// Close closes the underlying log file
+func (l *FileAuditLogger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// identityKey is the gin context key holding the authenticated identity
+const identityKey = "identity"
+
+// parseAuthTokens parses "token:identity" pairs separated by commas
+func parseAuthTokens(s string) (map[string]string, error) {
+	tokens := make(map[string]string)
+	for _, pair := range splitList(s) {
+		token, identity, ok := strings.Cut(pair, ":")
+		if !ok || token == "" || identity == "" {
+			return nil, fmt.Errorf("invalid auth token entry %q", pair)
+		}
+		tokens[token] = identity
+	}
+	return tokens, nil
+}
+
+// authenticate maps the bearer token to an identity. When no tokens are
+// configured authentication is disabled and requests pass through.
+func authenticate(tokens map[string]string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if len(tokens) == 0 {
+			c.Next()
+			return
+		}
+
+		identity, ok := tokenIdentity(tokens, c.GetHeader("Authorization"))
+		if !ok {
+			abortWithError(c, http.StatusUnauthorized, "Invalid or missing token")
+			return
+		}
+		c.Set(identityKey, identity)
+		c.Next()
+	}
+}
+
+// tokenIdentity returns the identity of the bearer token in an
+// Authorization header value, if it is one of tokens
+func tokenIdentity(tokens map[string]string, authorization string) (string, bool) {
+	token, ok := strings.CutPrefix(authorization, "Bearer ")
+	identity, known := tokens[token]
+	return identity, ok && known
+}
+
+// ErrSenderMismatch is returned when a request claims a sender other than
+// its authenticated identity
+var ErrSenderMismatch = errors.New("sender does not match authenticated identity")
+
+// senderFor returns the sender to record for a request. The claimed sender
+// is normalized and a malformed one rejected with ErrInvalidSender. An
+// authenticated identity always wins; a claimed sender that disagrees with
+// it is rejected with ErrSenderMismatch.
+func senderFor(claimed, identity string) (string, error) {
+	claimed, err := normalizeSender(claimed, maxSenderLength)
+	if err != nil {
+		return "", err
+	}
+	if identity == "" {
+		return claimed, nil
+	}
+	if claimed != "" && claimed != identity {
+		return "", ErrSenderMismatch
+	}
+	return identity, nil
+}
+
+// resolveSender is senderFor for a handler, taking the identity set by
+// authenticate and writing an error response for a rejected sender
+func resolveSender(c *gin.Context, claimed string) (string, bool) {
+	sender, err := senderFor(claimed, c.GetString(identityKey))
+	if errors.Is(err, ErrSenderMismatch) {
+		respondError(c, http.StatusForbidden, "Sender does not match authenticated identity")
+		return "", false
+	}
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return "", false
+	}
+	return sender, true
+}
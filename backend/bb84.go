@@ -1,12 +1,20 @@
 package main
 
 import (
-	"crypto/rand"
+	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
-	"math/big"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 )
 
+// MaxCiphertextBytes caps the decoded size of a ciphertext accepted for decryption
+const MaxCiphertextBytes = 1 << 20
+
 // Basis represents measurement basis (Z = 0, X = 1)
 type Basis int
 
@@ -15,133 +23,653 @@ const (
 	XBasis              // Hadamard basis {|+⟩, |-⟩}
 )
 
+// String returns the basis name used in traces and reports
+func (b Basis) String() string {
+	if b == XBasis {
+		return "X"
+	}
+	return "Z"
+}
+
 // Participant represents either Alice or Bob
 type Participant struct {
-	bits  []int
-	bases []Basis
-	name  string
+	bits         []int
+	bases        []Basis
+	measuredBits []int  // Measurement outcome per qubit; only set for Bob
+	missed       []bool // Qubits lost to detector dead time; only set for Bob
+	name         string
 }
 
-// Message represents an encrypted message
+// MessageKind distinguishes encrypted chat messages from metadata events
+type MessageKind string
+
+const (
+	KindEncrypted MessageKind = "encrypted" // Ciphertext that consumed key bytes
+	KindMeta      MessageKind = "meta"      // Unencrypted event such as typing or presence
+)
+
+// Message represents an encrypted message or a metadata event
 type Message struct {
-	Ciphertext string `json:"ciphertext"`
-	Sender     string `json:"sender"`
+	Seq        int         `json:"seq"`
+	Kind       MessageKind `json:"kind"`
+	Ciphertext string      `json:"ciphertext,omitempty"`
+	Sender     string      `json:"sender"`
+	Offset     int         `json:"offset"`
+	MAC        string      `json:"mac,omitempty"`
+	Room       string      `json:"room,omitempty"`       // Conversation within the session; empty is the default room
+	Compressed bool        `json:"compressed,omitempty"` // Plaintext was gzipped before encryption
+	Cascade    bool        `json:"cascade,omitempty"`    // OTP ciphertext was sealed again with AES-GCM
+	Watermark  bool        `json:"watermark,omitempty"`  // Plaintext carries watermark bytes; see addWatermark
+
+	MacAlgorithm MacAlgorithm `json:"macAlgorithm,omitempty"`
+	Supersedes   int          `json:"supersedes,omitempty"`   // Seq of the message this one edits
+	SupersededBy int          `json:"supersededBy,omitempty"` // Seq of the edit replacing this message
+	Type         string       `json:"type,omitempty"`
+	Timestamp    time.Time    `json:"timestamp"`
+}
+
+// ProtocolOptions tunes the post-processing stages of a protocol run
+type ProtocolOptions struct {
+	QBERSampleFraction float64       // Fraction of sifted bits disclosed to estimate QBER
+	QBERThreshold      float64       // QBER above which the run is aborted
+	Extractor          ExtractorType // Hash used for privacy amplification
+
+	// PrepFlawProbability is the chance that Alice's transmitter prepares the
+	// orthogonal state to the one intended, independent of channel and Eve
+	PrepFlawProbability float64
+
+	// InjectedErrorRate flips this fraction of Bob's sifted measurements
+	// before QBER estimation. It is a teaching aid that simulates an attack
+	// of tunable strength, not a model of any physical effect.
+	InjectedErrorRate float64
+
+	// InterceptResend places Eve on the channel: she measures every qubit in
+	// a random basis and resends the state she observed
+	InterceptResend bool
+
+	// MaxReconciliationPasses bounds the CASCADE passes run to correct Bob's
+	// key; zero selects DefaultMaxReconciliationPasses
+	MaxReconciliationPasses int
+
+	// MeasurementTiming controls when Bob's bases become public. Announcing
+	// them before measurement lets Eve measure in Bob's basis undetected.
+	MeasurementTiming MeasurementTiming
+
+	// MinKeyEntropy is the Shannon entropy, in bits per byte, below which a
+	// distilled key is rejected as the output of a failed RNG; zero disables
+	MinKeyEntropy float64
+
+	// TransmissionBatches is how many progress reports a run emits while
+	// transmitting qubits; zero selects DefaultTransmissionBatches
+	TransmissionBatches int
+
+	// DeadTimeSlots is how many qubit slots Bob's detector for a basis stays
+	// dead after each detection in that basis; missed qubits are not sifted
+	DeadTimeSlots int
+
+	// TargetSiftedBits, when positive, keeps sending batches of qubits until
+	// this many bits are sifted, then keeps exactly that many. NumberOfBits
+	// sizes the first batch and afterwards counts the raw bits consumed.
+	TargetSiftedBits int
+
+	// ClassicalLatency is the simulated round-trip time of the classical
+	// channel, charged for the basis announcement, the QBER sample, each
+	// reconciliation pass and the privacy amplification seed; zero disables
+	ClassicalLatency time.Duration
+
+	// Whitener debiases the random bits drawn for bits and bases before
+	// transmission and sifting
+	Whitener WhitenerType
+
+	// RandomQBERSample discloses uniformly chosen sifted positions to
+	// estimate QBER instead of a contiguous prefix, so the disclosed bits
+	// are not one block of the transmission
+	RandomQBERSample bool
+
+	// MinSiftingEfficiency is the lowest fraction of detected qubits sifting
+	// may keep, stated for BB84's ideal one half and scaled for variants
+	// with a lower yield; zero disables. Runs of fewer than
+	// minSiftingCheckQubits detected qubits are not checked.
+	MinSiftingEfficiency float64
 }
 
 // BB84Protocol represents the complete QKD protocol
 type BB84Protocol struct {
+	ProtocolOptions
 	Alice          Participant
 	Bob            Participant
 	NumberOfBits   int
 	SharedKey      []int
 	QuantumChannel []int
 	SecureChannel  *SecureChannel
+	Rand           RandSource
+	OnProgress     ProgressFunc // Optional; called as each stage of a run begins and during transmission
+
+	siftedIndices []int  // positions where Alice's and Bob's bases matched
+	siftedKey     []int  // Alice's key bits at siftedIndices, before sampling
+	bobSiftedKey  []int  // Bob's key bits at siftedIndices, before sampling
+	sifter        sifter // Sifting rule of a protocol variant; nil means BB84
+	sampleSize    int    // leading sifted bits disclosed for QBER estimation
+	qber          float64
+	stageStarts   []stageStart
+	transitions   []PhaseTransition
+	phase         Phase
+}
+
+// KeyReusePolicy controls what happens once the one-time pad is used up
+type KeyReusePolicy int
+
+const (
+	PolicyStrict KeyReusePolicy = iota // Refuse to encrypt once the key is exhausted
+	PolicyRepeat                       // Wrap around and reuse key bytes (repeating-key XOR)
+)
+
+func (p KeyReusePolicy) String() string {
+	if p == PolicyRepeat {
+		return "repeat"
+	}
+	return "strict"
+}
+
+// ChannelOptions configures how a SecureChannel uses its key
+type ChannelOptions struct {
+	ReusePolicy  KeyReusePolicy
+	MacAlgorithm MacAlgorithm // Authenticator for new messages; empty means HMAC-SHA256
+
+	// PartitionKeyBySender gives each of the two chat parties its own half of
+	// the key, so they never encrypt with the same bytes. It implies
+	// PolicyStrict within each half.
+	PartitionKeyBySender bool
+
+	// TimingBucket, when positive, pads every encryption and decryption to
+	// the next multiple of this duration so response times do not reveal
+	// message length or why authentication failed
+	TimingBucket time.Duration
+
+	// CompressBeforeEncrypt gzips each plaintext before encryption when that
+	// makes it shorter, so long messages consume fewer key bytes
+	CompressBeforeEncrypt bool
+
+	// RequireQBERCheck refuses to encrypt with a key whose protocol run did
+	// not sample any bits to estimate QBER, such as an imported key
+	RequireQBERCheck bool
+
+	// UnpaddedBase64 emits ciphertexts and MACs without trailing '=' padding.
+	// Decryption accepts either form regardless.
+	UnpaddedBase64 bool
+
+	// CascadeEncryption additionally encrypts each OTP ciphertext with
+	// AES-256-GCM under a key derived from the shared key, so a message stays
+	// computationally protected even if its pad bytes leak
+	CascadeEncryption bool
+
+	// MemoryProtection keeps stored messages sealed under a per-process
+	// ephemeral key, decrypting them only when they are read
+	MemoryProtection bool
+
+	// MaxPlaintextBytes, when positive, refuses to encrypt a message longer
+	// than this many bytes, measured before compression
+	MaxPlaintextBytes int
+
+	// WatermarkCheck interleaves known watermark bytes with each plaintext
+	// before encryption and verifies them on decryption, as a lightweight
+	// tamper signal alongside the MAC. Each message costs one extra key byte
+	// per 16 bytes of plaintext.
+	WatermarkCheck bool
 }
 
 // SecureChannel represents the communication channel between Alice and Bob
 type SecureChannel struct {
+	ChannelOptions
 	SharedKey []int
-	Messages  []Message
+	Messages  []Message // Empty when MemoryProtection seals them instead
+	Audit     AuditLogger
+
+	mu           sync.RWMutex // Held for reading while key material is used without advancing the offset
+	keyBytes     []byte
+	macKey       []byte
+	aesKey       []byte
+	watermarkKey []byte
+	offset       int // next unused key byte for EncryptMessage
+	parts        map[string]*keyPartition
+	senders      map[string]*SenderStats
+	lastSeq      int  // sequence number of the most recent message
+	checked      bool // QBER was estimated on a disclosed sample of the key
+
+	quarantined bool          // Disabled by an operator; see quarantine
+	onAppend    func(Message) // Called with sc.mu held as each message is stored; must not block
+	fingerprint string        // Kept after the key is wiped, to identify it in dumps
+
+	sealed []sealedMessage // Stored messages under MemoryProtection
 }
 
+// Errors returned by SecureChannel operations
+var (
+	ErrEmptyKey            = errors.New("shared key is empty")
+	ErrKeyRangeOutOfBounds = errors.New("key range out of bounds")
+	ErrCiphertextTooLarge  = errors.New("ciphertext too large")
+	ErrKeyExhausted        = errors.New("key exhausted")
+	ErrMalformedCiphertext = errors.New("failed to decode ciphertext")
+	ErrQBERNotChecked      = errors.New("key was never checked for eavesdropping")
+	ErrEmptyPlaintext      = errors.New("plaintext is empty")
+	ErrDegenerateKey       = errors.New("key bits are all identical")
+	ErrMessageTooLong      = errors.New("message too long")
+)
+
 // NewBB84Protocol creates a new instance of the BB84 protocol
 func NewBB84Protocol(bits int) *BB84Protocol {
 	return &BB84Protocol{
-		Alice:        Participant{name: "Alice"},
-		Bob:          Participant{name: "Bob"},
-		NumberOfBits: bits,
+		Alice:           Participant{name: "Alice"},
+		Bob:             Participant{name: "Bob"},
+		NumberOfBits:    bits,
+		Rand:            NewRetryRandSource(cryptoRandPool, DefaultRandRetries),
+		phase:           PhaseCreated,
+		ProtocolOptions: DefaultProtocolOptions(),
 	}
 }
 
-// generateRandomBits creates random classical bits
-func (p *Participant) generateRandomBits(n int) error {
-	p.bits = make([]int, n)
-	for i := 0; i < n; i++ {
-		num, err := rand.Int(rand.Reader, big.NewInt(2))
+// DefaultProtocolOptions returns the options used when none are configured
+func DefaultProtocolOptions() ProtocolOptions {
+	return ProtocolOptions{
+		QBERSampleFraction: DefaultQBERSampleFraction,
+		QBERThreshold:      DefaultQBERThreshold,
+		Extractor:          ExtractorToeplitz,
+
+		MinSiftingEfficiency: DefaultMinSiftingEfficiency,
+	}
+}
+
+// generateRandomBits appends n random classical bits
+func (p *Participant) generateRandomBits(src RandSource, n int) error {
+	p.bits = append(p.bits, make([]int, n)...)
+	for i := len(p.bits) - n; i < len(p.bits); i++ {
+		bit, err := src.Bit()
 		if err != nil {
 			return fmt.Errorf("failed to generate random bit: %v", err)
 		}
-		p.bits[i] = int(num.Int64())
+		p.bits[i] = bit
 	}
 	return nil
 }
 
-// generateRandomBases creates random measurement bases
-func (p *Participant) generateRandomBases(n int) error {
-	p.bases = make([]Basis, n)
-	for i := 0; i < n; i++ {
-		num, err := rand.Int(rand.Reader, big.NewInt(2))
+// generateRandomBases appends n random measurement bases
+func (p *Participant) generateRandomBases(src RandSource, n int) error {
+	p.bases = append(p.bases, make([]Basis, n)...)
+	for i := len(p.bases) - n; i < len(p.bases); i++ {
+		bit, err := src.Bit()
 		if err != nil {
 			return fmt.Errorf("failed to generate random basis: %v", err)
 		}
-		p.bases[i] = Basis(num.Int64())
+		p.bases[i] = Basis(bit)
 	}
 	return nil
 }
 
-// simulateQuantumTransmission simulates quantum state preparation and transmission
-func (bb84 *BB84Protocol) simulateQuantumTransmission() {
-	bb84.QuantumChannel = make([]int, bb84.NumberOfBits)
-	for i := 0; i < bb84.NumberOfBits; i++ {
+// ErrParameterMismatch is returned when Alice's and Bob's parameters disagree
+// with each other or with the protocol's bit count
+var ErrParameterMismatch = errors.New("participant parameters do not match")
+
+// checkParameters verifies that Alice and Bob both prepared NumberOfBits
+// qubits' worth of bits and bases, since any disagreement would misalign
+// every position compared during sifting
+func (bb84 *BB84Protocol) checkParameters() error {
+	n := bb84.NumberOfBits
+	if len(bb84.Alice.bits) != n || len(bb84.Alice.bases) != n || len(bb84.Bob.bases) != n {
+		return fmt.Errorf("%w: protocol has %d bits, alice %d bits and %d bases, bob %d bases",
+			ErrParameterMismatch, n, len(bb84.Alice.bits), len(bb84.Alice.bases), len(bb84.Bob.bases))
+	}
+	return nil
+}
+
+// simulateQuantumTransmission simulates quantum state preparation and
+// transmission of the qubits not yet sent, reporting progress after each
+// batch of qubits. It stops between batches once ctx is done.
+func (bb84 *BB84Protocol) simulateQuantumTransmission(ctx context.Context) error {
+	start := len(bb84.QuantumChannel)
+	bb84.QuantumChannel = append(bb84.QuantumChannel, make([]int, bb84.NumberOfBits-start)...)
+	batch := bb84.transmissionBatchSize()
+	for i := start; i < bb84.NumberOfBits; i++ {
+		if i > start && (i-start)%batch == 0 {
+			bb84.reportTransmission(i)
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+		if bb84.InterceptResend {
+			bit, err := bb84.interceptResend(i)
+			if err != nil {
+				return err
+			}
+			bb84.QuantumChannel[i] = bit
+			continue
+		}
 		if bb84.Alice.bases[i] == bb84.Bob.bases[i] {
-			bb84.QuantumChannel[i] = bb84.Alice.bits[i]
+			prepared := bb84.Alice.bits[i]
+			flawed, err := bernoulli(bb84.Rand, bb84.PrepFlawProbability)
+			if err != nil {
+				return fmt.Errorf("failed to simulate state preparation: %v", err)
+			}
+			if flawed {
+				prepared ^= 1
+			}
+			bb84.QuantumChannel[i] = prepared
 		} else {
-			num, _ := rand.Int(rand.Reader, big.NewInt(2))
-			bb84.QuantumChannel[i] = int(num.Int64())
+			bit, err := bb84.Rand.Bit()
+			if err != nil {
+				return fmt.Errorf("failed to generate measurement outcome: %v", err)
+			}
+			bb84.QuantumChannel[i] = bit
 		}
 	}
+	bb84.reportTransmission(bb84.NumberOfBits)
+	bb84.Bob.measuredBits = append([]int(nil), bb84.QuantumChannel...)
+	bb84.Bob.missed = deadTimeMisses(bb84.Bob.bases, bb84.DeadTimeSlots)
+	return nil
 }
 
-// generateSharedKey creates the final shared key from matching bases
+// generateSharedKey creates the sifted key from detected qubits with matching bases
 func (bb84 *BB84Protocol) generateSharedKey() {
 	bb84.SharedKey = make([]int, 0)
+	bb84.siftedIndices = make([]int, 0)
+	bb84.bobSiftedKey = make([]int, 0)
 	for i := 0; i < bb84.NumberOfBits; i++ {
-		if bb84.Alice.bases[i] == bb84.Bob.bases[i] {
+		if bb84.detected(i) && bb84.Alice.bases[i] == bb84.Bob.bases[i] {
 			bb84.SharedKey = append(bb84.SharedKey, bb84.Alice.bits[i])
+			bb84.siftedIndices = append(bb84.siftedIndices, i)
+			bb84.bobSiftedKey = append(bb84.bobSiftedKey, bb84.Bob.measuredBits[i])
 		}
 	}
+	bb84.siftedKey = bb84.SharedKey
 }
 
-// NewSecureChannel creates a new SecureChannel using the shared key
-func NewSecureChannel(sharedKey []int) *SecureChannel {
-	return &SecureChannel{
-		SharedKey: sharedKey,
-		Messages:  make([]Message, 0),
+// NewSecureChannel creates a new SecureChannel using the shared key. A key
+// whose bits are all identical is refused with ErrDegenerateKey: XOR with
+// it leaves the plaintext readable, or merely inverted, in the ciphertext.
+func NewSecureChannel(sharedKey []int) (*SecureChannel, error) {
+	return newSecureChannel(sharedKey, KDFHKDF)
+}
+
+// NewPresharedChannel creates a SecureChannel for an imported key, deriving
+// its subkeys with kdf since a preshared key may carry little entropy
+func NewPresharedChannel(key []int, kdf KDF) (*SecureChannel, error) {
+	return newSecureChannel(key, kdf)
+}
+
+func newSecureChannel(sharedKey []int, kdf KDF) (*SecureChannel, error) {
+	if degenerateKey(sharedKey) {
+		log.Printf("SECURITY: refusing a degenerate %d-bit key whose bits are all %d", len(sharedKey), keyBit(sharedKey[0]))
+		return nil, ErrDegenerateKey
 	}
+	keyBytes := convertKeyToBytes(sharedKey)
+	macKey := deriveSubkey(kdf, keyBytes, macKeyLabel)
+	return &SecureChannel{
+		SharedKey:    sharedKey,
+		Messages:     make([]Message, 0),
+		Audit:        nopAuditLogger{},
+		keyBytes:     keyBytes,
+		macKey:       macKey,
+		aesKey:       deriveSubkey(kdf, keyBytes, cascadeKeyLabel),
+		watermarkKey: deriveSubkey(kdf, keyBytes, watermarkKeyLabel),
+		fingerprint:  keyFingerprint(macKey),
+	}, nil
 }
 
 // RunProtocol executes the complete BB84 protocol and initializes the secure channel
-func (bb84 *BB84Protocol) RunProtocol() error {
-	if err := bb84.Alice.generateRandomBits(bb84.NumberOfBits); err != nil {
+func (bb84 *BB84Protocol) RunProtocol(ctx context.Context) error {
+	result, err := bb84.RunProtocolWithResult(ctx)
+	if err != nil {
+		return err
+	}
+	if result.Aborted {
+		return fmt.Errorf("%w: %s", ErrProtocolAborted, result.Reason)
+	}
+	return nil
+}
+
+// RunProtocolWithResult executes the protocol and reports QBER and sifting
+// diagnostics. The returned error covers internal failures; an aborted run
+// is reported through the result and leaves SecureChannel nil, including a
+// run interrupted by ctx or failing the sifting efficiency check, whose
+// result holds the diagnostics gathered so far.
+func (bb84 *BB84Protocol) RunProtocolWithResult(ctx context.Context) (*ProtocolResult, error) {
+	if err := bb84.RunUntilSifted(ctx); err != nil {
+		reason := contextReason(ctx)
+		if errors.Is(err, ErrLowSiftingEfficiency) {
+			reason = ReasonLowSiftingEfficiency
+		} else if ctx.Err() == nil {
+			return nil, err
+		}
+		result := &ProtocolResult{SiftedLength: len(bb84.SharedKey)}
+		result.Distillation.RawBits = bb84.NumberOfBits
+		result.Distillation.SiftedBits = len(bb84.SharedKey)
+		result.abort(reason)
+		return result, nil
+	}
+	return bb84.Resume(ctx)
+}
+
+// runQuantumBatch prepares and transmits n more qubits, then sifts every
+// qubit sent so far
+func (bb84 *BB84Protocol) runQuantumBatch(ctx context.Context, n int) error {
+	bb84.NumberOfBits += n
+	bb84.reportProgress(StageGeneratingBits)
+	if err := bb84.Alice.generateRandomBits(bb84.Rand, n); err != nil {
 		return fmt.Errorf("alice bits generation failed: %v", err)
 	}
-	if err := bb84.Alice.generateRandomBases(bb84.NumberOfBits); err != nil {
+	if err := bb84.Alice.generateRandomBases(bb84.Rand, n); err != nil {
 		return fmt.Errorf("alice bases generation failed: %v", err)
 	}
-	if err := bb84.Bob.generateRandomBases(bb84.NumberOfBits); err != nil {
+	if err := bb84.Bob.generateRandomBases(bb84.Rand, n); err != nil {
 		return fmt.Errorf("bob bases generation failed: %v", err)
 	}
-	bb84.simulateQuantumTransmission()
-	bb84.generateSharedKey()
+	if err := bb84.checkParameters(); err != nil {
+		return err
+	}
+	bb84.reportProgress(StageTransmitting)
+	if err := bb84.simulateQuantumTransmission(ctx); err != nil {
+		return fmt.Errorf("quantum transmission failed: %v", err)
+	}
+	bb84.reportProgress(StageSifting)
+	if err := bb84.classicalExchange(ctx, 1); err != nil {
+		return fmt.Errorf("basis announcement failed: %v", err)
+	}
+	if bb84.sifter != nil {
+		if err := bb84.sifter.sift(bb84); err != nil {
+			return fmt.Errorf("sifting failed: %v", err)
+		}
+	} else {
+		bb84.generateSharedKey()
+	}
+	return nil
+}
 
-	// Initialize the SecureChannel using the shared key
-	bb84.SecureChannel = NewSecureChannel(bb84.SharedKey)
+// RunUntilSifted performs the quantum phase (preparation, transmission and
+// basis sifting) and pauses before error estimation. It stops early with an
+// error once ctx is done, and fails with ErrLowSiftingEfficiency when too
+// few bases matched.
+func (bb84 *BB84Protocol) RunUntilSifted(ctx context.Context) error {
+	if bb84.phase != PhaseCreated {
+		return fmt.Errorf("%w: cannot sift in phase %q", ErrInvalidPhase, bb84.phase)
+	}
+	src := bb84.Rand
+	bb84.Rand = newContextRandSource(ctx, NewWhitenedRandSource(src, bb84.Whitener))
+	defer func() { bb84.Rand = src }()
+
+	// With a sifted-bit target, batches are sent until it is reached
+	batch := bb84.NumberOfBits
+	if batch == 0 && bb84.TargetSiftedBits > 0 {
+		batch = bb84.targetBatchSize()
+	}
+	bb84.NumberOfBits = 0
+	for {
+		if err := bb84.runQuantumBatch(ctx, batch); err != nil {
+			return err
+		}
+		if bb84.TargetSiftedBits <= 0 || len(bb84.SharedKey) >= bb84.TargetSiftedBits {
+			break
+		}
+		batch = bb84.targetBatchSize()
+		if bb84.NumberOfBits+batch > maxBits {
+			return fmt.Errorf("%w: %d sifted bits after %d raw bits", ErrTargetUnreachable, len(bb84.SharedKey), bb84.NumberOfBits)
+		}
+	}
+	if err := bb84.checkSiftingEfficiency(); err != nil {
+		return err
+	}
+	if bb84.TargetSiftedBits > 0 {
+		bb84.truncateToTarget()
+	}
+
+	if err := bb84.injectErrors(); err != nil {
+		return fmt.Errorf("error injection failed: %v", err)
+	}
+
+	bb84.phase = PhaseSifted
+	bb84.recordTransition(PhaseCreated, nil)
 	return nil
 }
 
-// convertKeyToBytes converts bit array to byte array
+// Resume runs the classical post-processing (QBER estimation and privacy
+// amplification) of a protocol paused after sifting. If ctx is done during a
+// stage the run is aborted with the diagnostics gathered so far.
+func (bb84 *BB84Protocol) Resume(ctx context.Context) (*ProtocolResult, error) {
+	if bb84.phase != PhaseSifted {
+		return nil, fmt.Errorf("%w: cannot resume in phase %q", ErrInvalidPhase, bb84.phase)
+	}
+	bb84.phase = PhaseComplete
+	result, err := bb84.postProcess(ctx)
+	if err == nil {
+		bb84.recordTransition(PhaseSifted, result)
+	}
+	return result, err
+}
+
+// postProcess estimates the QBER, reconciles and amplifies the sifted key,
+// and builds the secure channel on the result
+func (bb84 *BB84Protocol) postProcess(ctx context.Context) (*ProtocolResult, error) {
+	// The public randomness of sampling, reconciliation and the extractor
+	// seed is drawn through ctx too, so a long draw stops with the budget
+	src := bb84.Rand
+	bb84.Rand = newContextRandSource(ctx, src)
+	defer func() { bb84.Rand = src }()
+
+	result := &ProtocolResult{SiftedLength: len(bb84.SharedKey)}
+	result.Distillation.RawBits = bb84.NumberOfBits
+	result.Distillation.SiftedBits = len(bb84.SharedKey)
+	bb84.reportProgress(StageEstimatingQBER)
+	if bb84.classicalExchange(ctx, 1) != nil {
+		result.abort(contextReason(ctx))
+		return result, nil
+	}
+	qber, err := bb84.estimateQBER()
+	if err != nil {
+		if ctx.Err() != nil {
+			result.abort(contextReason(ctx))
+			return result, nil
+		}
+		return nil, err
+	}
+	result.Qber = qber
+	bb84.qber = result.Qber
+	result.Distillation.SampledBits = result.SiftedLength - len(bb84.SharedKey)
+	if ctx.Err() != nil {
+		result.abort(contextReason(ctx))
+		return result, nil
+	}
+
+	if result.Qber > bb84.QBERThreshold {
+		result.abort(ReasonEavesdropper)
+		bb84.reportProgress(StageDone)
+		return result, nil
+	}
+
+	if err := bb84.ReconcileKeys(ctx, result.Qber, &result.Distillation); err != nil {
+		if ctx.Err() != nil {
+			result.abort(contextReason(ctx))
+			return result, nil
+		}
+		if !errors.Is(err, ErrReconciliationFailed) {
+			return nil, err
+		}
+		result.abort(ReasonReconciliationFailed)
+		bb84.reportProgress(StageDone)
+		return result, nil
+	}
+
+	// Reconciliation passes and the extractor seed each cost a round trip,
+	// charged once the local computation is done
+	if bb84.classicalExchange(ctx, result.Distillation.ReconciliationPasses+1) != nil {
+		result.abort(contextReason(ctx))
+		return result, nil
+	}
+	if err := bb84.amplifyPrivacy(ctx, result.Qber, &result.Distillation); err != nil {
+		if ctx.Err() != nil {
+			result.abort(contextReason(ctx))
+			return result, nil
+		}
+		return nil, fmt.Errorf("privacy amplification failed: %v", err)
+	}
+	if len(bb84.SharedKey) == 0 {
+		result.abort(ReasonInsufficientKey)
+		bb84.reportProgress(StageDone)
+		return result, nil
+	}
+
+	// Initialize the SecureChannel using the shared key
+	channel, err := NewSecureChannel(bb84.SharedKey)
+	if err != nil {
+		result.abort(ReasonDegenerateKey)
+		bb84.reportProgress(StageDone)
+		return result, nil
+	}
+	bb84.SecureChannel = channel
+	bb84.SecureChannel.checked = bb84.sampleSize > 0
+	if bb84.SecureChannel.KeyEntropy() < bb84.MinKeyEntropy {
+		bb84.SecureChannel.wipe()
+		bb84.SecureChannel = nil
+		result.abort(ReasonLowKeyEntropy)
+		bb84.reportProgress(StageDone)
+		return result, nil
+	}
+	bb84.reportProgress(StageDone)
+	return result, nil
+}
+
+// keyBit returns 1 for a set key bit and 0 otherwise
+func keyBit(v int) byte {
+	if v == 1 {
+		return 1
+	}
+	return 0
+}
+
+// convertKeyToBytes converts bit array to byte array, packing whole bytes
+// at a time and handling any trailing partial byte separately
 func convertKeyToBytes(key []int) []byte {
 	byteLen := (len(key) + 7) / 8
 	bytes := make([]byte, byteLen)
 
-	for i := 0; i < len(key); i++ {
-		if key[i] == 1 {
-			bytes[i/8] |= 1 << uint(7-i%8)
-		}
+	full := len(key) / 8
+	for i := 0; i < full; i++ {
+		b := key[i*8 : i*8+8 : i*8+8]
+		bytes[i] = keyBit(b[0])<<7 | keyBit(b[1])<<6 | keyBit(b[2])<<5 | keyBit(b[3])<<4 |
+			keyBit(b[4])<<3 | keyBit(b[5])<<2 | keyBit(b[6])<<1 | keyBit(b[7])
+	}
+	for i := full * 8; i < len(key); i++ {
+		bytes[i/8] |= keyBit(key[i]) << uint(7-i%8)
 	}
 	return bytes
 }
 
+// bytesToKey unpacks a byte array into key bits, most significant bit first
+func bytesToKey(data []byte) []int {
+	key := make([]int, len(data)*8)
+	for i := range key {
+		key[i] = int(data[i/8]>>uint(7-i%8)) & 1
+	}
+	return key
+}
+
 // xorBytes performs XOR operation on byte slices
 func xorBytes(a, b []byte) []byte {
 	result := make([]byte, len(a))
@@ -151,32 +679,397 @@ func xorBytes(a, b []byte) []byte {
 	return result
 }
 
-// EncryptMessage encrypts a message using the shared key
+// keyStream returns n key bytes starting at offset, wrapping around the key
+// when it is shorter than the requested range
+func (sc *SecureChannel) keyStream(offset, n int) ([]byte, error) {
+	if len(sc.keyBytes) == 0 {
+		return nil, ErrEmptyKey
+	}
+	// A message's offset comes from the client, so it may be negative or
+	// large enough to overflow once the message length is added
+	if offset < 0 {
+		return nil, fmt.Errorf("%w: negative offset %d", ErrKeyRangeOutOfBounds, offset)
+	}
+	start := offset % len(sc.keyBytes)
+	stream := make([]byte, n)
+	for i := range stream {
+		stream[i] = sc.keyBytes[(start+i)%len(sc.keyBytes)]
+	}
+	return stream, nil
+}
+
+// appendMessage assigns the next sequence number and stores the message.
+// The caller must hold sc.mu for writing. Numbering and storing under the
+// same lock is what keeps stored messages sorted by strictly increasing
+// sequence number, however many writers race; Page, History and
+// EditMessage rely on it.
+func (sc *SecureChannel) appendMessage(msg *Message) {
+	sc.lastSeq++
+	msg.Seq = sc.lastSeq
+	sc.putMessage(sc.messageCount(), *msg)
+	if sc.onAppend != nil {
+		sc.onAppend(*msg)
+	}
+}
+
+// notifyAppends sets the function called with each newly stored message,
+// or clears it when f is nil
+func (sc *SecureChannel) notifyAppends(f func(Message)) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.onAppend = f
+}
+
+// Page returns up to limit messages with a sequence number below before
+// (or the latest messages when before is 0), oldest first. nextCursor is the
+// value to pass as before for the preceding page, or 0 when none remain.
+func (sc *SecureChannel) Page(before, limit int) (page []Message, nextCursor int) {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+
+	end := sc.messageCount()
+	if before > 0 {
+		end = sort.Search(end, func(i int) bool { return sc.messageSeq(i) >= before })
+	}
+	start := end - limit
+	if start < 0 {
+		start = 0
+	}
+
+	page = sc.messagesIn(start, end)
+	if start > 0 {
+		nextCursor = sc.messageSeq(start)
+	}
+	return page, nextCursor
+}
+
+// History returns a snapshot of the stored messages, sorted by sequence number
+func (sc *SecureChannel) History() []Message {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+
+	return sc.messagesIn(0, sc.messageCount())
+}
+
+// Offset returns the next unused key byte offset
+func (sc *SecureChannel) Offset() int {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+	return sc.offset
+}
+
+// Remaining returns how many key bytes are left before the key is exhausted
+func (sc *SecureChannel) Remaining() int {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+	if sc.PartitionKeyBySender {
+		return sc.partitionRemaining()
+	}
+	return max(0, len(sc.keyBytes)-sc.offset)
+}
+
+// wipe zeroes the channel's key material and leaves it unusable, waiting
+// for any decryption already in flight to finish
+func (sc *SecureChannel) wipe() {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	clear(sc.SharedKey)
+	clear(sc.keyBytes)
+	clear(sc.macKey)
+	clear(sc.aesKey)
+	clear(sc.watermarkKey)
+	sc.SharedKey, sc.keyBytes, sc.macKey, sc.aesKey, sc.watermarkKey = nil, nil, nil, nil, nil
+}
+
+// EncryptMessage encrypts a message at the current key offset and advances it
 func (sc *SecureChannel) EncryptMessage(plaintext string, sender string) (*Message, error) {
-	keyBytes := convertKeyToBytes(sc.SharedKey)
+	defer sc.padTiming(time.Now())
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.encryptNext(plaintext, sender, "", 0)
+}
+
+// encryptNext encrypts and stores a message for room at the sender's next
+// offset, recording the sequence number it supersedes, if any. The caller
+// must hold sc.mu.
+func (sc *SecureChannel) encryptNext(plaintext, sender, room string, supersedes int) (*Message, error) {
+	msg, err := sc.sealNext(plaintext, sender, room)
+	if err != nil {
+		return nil, err
+	}
+	msg.Supersedes = supersedes
+	sc.appendMessage(msg)
+	return msg, nil
+}
+
+// checkPlaintextLength enforces MaxPlaintextBytes
+func (sc *SecureChannel) checkPlaintextLength(plaintext string) error {
+	if sc.MaxPlaintextBytes > 0 && len(plaintext) > sc.MaxPlaintextBytes {
+		return fmt.Errorf("%w: %d bytes, limit is %d", ErrMessageTooLong, len(plaintext), sc.MaxPlaintextBytes)
+	}
+	return nil
+}
+
+// sealNext encrypts plaintext for room at the sender's next offset and
+// consumes the key bytes it used, without storing the message. The caller
+// must hold sc.mu.
+func (sc *SecureChannel) sealNext(plaintext, sender, room string) (*Message, error) {
+	if sc.quarantined {
+		return nil, ErrSessionQuarantined
+	}
+	if sc.RequireQBERCheck && !sc.checked {
+		return nil, ErrQBERNotChecked
+	}
+	// An empty message would consume no key and carry only a MAC over the
+	// sender and offset, so it is refused rather than given a meaning
+	if plaintext == "" {
+		return nil, ErrEmptyPlaintext
+	}
+	if err := sc.checkPlaintextLength(plaintext); err != nil {
+		return nil, err
+	}
+	msg, n, err := sc.sealPlaintext(plaintext, sender, room)
+	if err != nil {
+		return nil, err
+	}
+	sc.advance(sender, n)
+	sc.recordEncryption(sender, n)
+
+	// Record key consumption, never the key bytes themselves
+	if err := sc.Audit.Record(AuditEvent{Type: AuditOffsetAdvanced, Bytes: n, Offset: msg.Offset, Sender: sender}); err != nil {
+		log.Printf("audit: %v", err)
+	}
+	return msg, nil
+}
+
+// sealPlaintext encrypts plaintext for room at the sender's next offset and
+// returns the message with the number of key bytes it used, leaving the
+// caller to consume them. The caller must hold sc.mu.
+func (sc *SecureChannel) sealPlaintext(plaintext, sender, room string) (*Message, int, error) {
+	// A wiped channel, such as an evicted session's, has no key or subkeys
+	// left to encrypt or watermark with
+	if len(sc.keyBytes) == 0 {
+		return nil, 0, fmt.Errorf("%w: the key was wiped", ErrKeyExhausted)
+	}
+	plaintextBytes, compressed := sc.compressPlaintext([]byte(plaintext))
+	if sc.WatermarkCheck {
+		plaintextBytes = sc.addWatermark(plaintextBytes)
+	}
+	offset, err := sc.nextOffset(sender, len(plaintextBytes))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	keyBytes, err := sc.keyStream(offset, len(plaintextBytes))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	cipherBytes := xorBytes(plaintextBytes, keyBytes)
+	if sc.CascadeEncryption {
+		if cipherBytes, err = sc.sealCascade(cipherBytes, offset, sender); err != nil {
+			return nil, 0, err
+		}
+	}
+	ciphertext := sc.encoding().EncodeToString(cipherBytes)
+
+	algorithm := sc.macAlgorithm()
+	mac, err := sc.computeMAC(algorithm, offset, sender, messageFlags{compressed, sc.CascadeEncryption, sc.WatermarkCheck, room}, cipherBytes)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	msg := &Message{
+		Kind:         KindEncrypted,
+		Ciphertext:   ciphertext,
+		Sender:       sender,
+		Room:         room,
+		Offset:       offset,
+		MAC:          mac,
+		Compressed:   compressed,
+		Cascade:      sc.CascadeEncryption,
+		Watermark:    sc.WatermarkCheck,
+		MacAlgorithm: algorithm,
+		Timestamp:    time.Now().UTC(),
+	}
+	return msg, len(plaintextBytes), nil
+}
+
+// ReserveKey atomically claims the next n key bytes and returns their offset,
+// so concurrent writers can encrypt into disjoint ranges with EncryptAt
+func (sc *SecureChannel) ReserveKey(n int) (int, error) {
+	if n <= 0 {
+		return 0, fmt.Errorf("%w: reservation must be positive, got %d", ErrKeyRangeOutOfBounds, n)
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if sc.quarantined {
+		return 0, ErrSessionQuarantined
+	}
+	if sc.PartitionKeyBySender {
+		return 0, ErrKeyPartitioned
+	}
+	if sc.offset+n > len(sc.keyBytes) {
+		return 0, fmt.Errorf("%w: %d bytes requested, %d remaining", ErrKeyExhausted, n, len(sc.keyBytes)-sc.offset)
+	}
+	offset := sc.offset
+	sc.offset += n
+
+	if err := sc.Audit.Record(AuditEvent{Type: AuditOffsetAdvanced, Bytes: n, Offset: offset}); err != nil {
+		log.Printf("audit: %v", err)
+	}
+	return offset, nil
+}
+
+// EncryptAt encrypts a message using the key bytes starting at offset without
+// advancing the channel offset. The caller is responsible for never reusing a range.
+func (sc *SecureChannel) EncryptAt(plaintext string, offset int) (*Message, error) {
 	plaintextBytes := []byte(plaintext)
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+
+	if sc.quarantined {
+		return nil, ErrSessionQuarantined
+	}
+	if sc.RequireQBERCheck && !sc.checked {
+		return nil, ErrQBERNotChecked
+	}
+	if plaintext == "" {
+		return nil, ErrEmptyPlaintext
+	}
+	if err := sc.checkPlaintextLength(plaintext); err != nil {
+		return nil, err
+	}
+
+	if offset < 0 || offset+len(plaintextBytes) > len(sc.keyBytes) {
+		return nil, fmt.Errorf("%w: %d bytes at offset %d, key has %d bytes",
+			ErrKeyRangeOutOfBounds, len(plaintextBytes), offset, len(sc.keyBytes))
+	}
+
+	keyBytes, err := sc.keyStream(offset, len(plaintextBytes))
+	if err != nil {
+		return nil, err
+	}
 
 	cipherBytes := xorBytes(plaintextBytes, keyBytes)
-	ciphertext := base64.StdEncoding.EncodeToString(cipherBytes)
+
+	algorithm := sc.macAlgorithm()
+	mac, err := sc.computeMAC(algorithm, offset, "", messageFlags{}, cipherBytes)
+	if err != nil {
+		return nil, err
+	}
 
 	msg := &Message{
-		Ciphertext: ciphertext,
-		Sender:     sender,
+		Kind:         KindEncrypted,
+		Ciphertext:   sc.encoding().EncodeToString(cipherBytes),
+		Offset:       offset,
+		MAC:          mac,
+		MacAlgorithm: algorithm,
+		Timestamp:    time.Now().UTC(),
 	}
 
-	sc.Messages = append(sc.Messages, *msg)
+	if err := sc.Audit.Record(AuditEvent{Type: AuditRangeUsed, Bytes: len(plaintextBytes), Offset: offset}); err != nil {
+		log.Printf("audit: %v", err)
+	}
 	return msg, nil
 }
 
-// DecryptMessage decrypts a message using the shared key
+// RecordMeta appends an unencrypted metadata event to the message stream
+// without consuming any key material
+func (sc *SecureChannel) RecordMeta(sender, eventType string) *Message {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	msg := &Message{
+		Kind:      KindMeta,
+		Sender:    sender,
+		Type:      eventType,
+		Timestamp: time.Now().UTC(),
+	}
+	sc.appendMessage(msg)
+	return msg
+}
+
+// padTiming sleeps until the time since start reaches a multiple of
+// TimingBucket. It is deferred before any lock is taken so the wait never
+// holds the channel.
+func (sc *SecureChannel) padTiming(start time.Time) {
+	if sc.TimingBucket <= 0 {
+		return
+	}
+	elapsed := time.Since(start)
+	target := (elapsed/sc.TimingBucket + 1) * sc.TimingBucket
+	time.Sleep(target - elapsed)
+}
+
+// encoding returns the base64 encoding of the ciphertexts and MACs this
+// channel emits
+func (sc *SecureChannel) encoding() *base64.Encoding {
+	if sc.UnpaddedBase64 {
+		return base64.RawStdEncoding
+	}
+	return base64.StdEncoding
+}
+
+// decodeCiphertext decodes a message's base64 ciphertext within the size
+// limit, with or without padding, since some clients strip it
+func (sc *SecureChannel) decodeCiphertext(msg *Message) ([]byte, error) {
+	encoded := strings.TrimRight(strings.TrimSpace(msg.Ciphertext), "=")
+	if base64.RawStdEncoding.DecodedLen(len(encoded)) > MaxCiphertextBytes {
+		return nil, fmt.Errorf("%w: limit is %d bytes", ErrCiphertextTooLarge, MaxCiphertextBytes)
+	}
+
+	cipherBytes, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedCiphertext, err)
+	}
+	return cipherBytes, nil
+}
+
+// DecryptMessage authenticates a message and decrypts it using the key
+// bytes at the message offset. It depends only on msg and the immutable key
+// material, never on the channel offset or stored messages, so any number of
+// decryptions may run in parallel with each other and with encryption.
 func (sc *SecureChannel) DecryptMessage(msg *Message) (string, error) {
-	keyBytes := convertKeyToBytes(sc.SharedKey)
+	defer sc.padTiming(time.Now())
+	cipherBytes, err := sc.decodeCiphertext(msg)
+	if err != nil {
+		return "", err
+	}
+
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+	if sc.quarantined {
+		return "", ErrSessionQuarantined
+	}
+	if err := sc.verifyMAC(msg, cipherBytes); err != nil {
+		return "", err
+	}
+
+	if msg.Cascade {
+		if cipherBytes, err = sc.openCascade(cipherBytes, msg.Offset, msg.Sender); err != nil {
+			return "", err
+		}
+	}
 
-	cipherBytes, err := base64.StdEncoding.DecodeString(msg.Ciphertext)
+	keyBytes, err := sc.keyStream(msg.Offset, len(cipherBytes))
 	if err != nil {
-		return "", fmt.Errorf("failed to decode ciphertext: %v", err)
+		return "", err
 	}
 
 	plaintextBytes := xorBytes(cipherBytes, keyBytes)
+	if msg.Watermark {
+		if plaintextBytes, err = sc.stripWatermark(plaintextBytes); err != nil {
+			return "", err
+		}
+	}
+	if msg.Compressed {
+		if plaintextBytes, err = decompressPlaintext(plaintextBytes); err != nil {
+			return "", err
+		}
+	}
 	return string(plaintextBytes), nil
 }
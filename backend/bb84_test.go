@@ -0,0 +1,395 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"testing"
+	"testing/quick"
+)
+
+// randomChannel returns a channel over n random key bytes drawn from r
+func randomChannel(tb testing.TB, r *rand.Rand, n int) *SecureChannel {
+	tb.Helper()
+	keyBytes := make([]byte, n)
+	r.Read(keyBytes)
+	keyBytes[0] = keyBytes[0]&^1 | 2 // Never all-identical, which NewSecureChannel refuses
+
+	sc, err := NewSecureChannel(bytesToKey(keyBytes))
+	if err != nil {
+		tb.Fatalf("NewSecureChannel: %v", err)
+	}
+	return sc
+}
+
+// TestOneTimePadProperty checks the one-time pad invariants over random
+// keys, offsets and plaintexts: decrypting an encryption returns the
+// plaintext, and ciphertext XOR plaintext is the key segment at the offset
+func TestOneTimePadProperty(t *testing.T) {
+	property := func(seed int64, offset, length uint16) bool {
+		r := rand.New(rand.NewSource(seed))
+		off := int(offset % 2048)
+		plaintext := make([]byte, int(length%1024)+1)
+		r.Read(plaintext)
+		sc := randomChannel(t, r, off+len(plaintext)+r.Intn(64))
+
+		if off > 0 {
+			if _, err := sc.ReserveKey(off); err != nil {
+				t.Logf("ReserveKey(%d): %v", off, err)
+				return false
+			}
+		}
+		msg, err := sc.EncryptMessage(string(plaintext), "alice")
+		if err != nil {
+			t.Logf("EncryptMessage: %v", err)
+			return false
+		}
+		if msg.Offset != off {
+			t.Logf("offset = %d, want %d", msg.Offset, off)
+			return false
+		}
+
+		decrypted, err := sc.DecryptMessage(msg)
+		if err != nil || decrypted != string(plaintext) {
+			t.Logf("DecryptMessage = %q, %v", decrypted, err)
+			return false
+		}
+
+		cipherBytes, err := sc.decodeCiphertext(msg)
+		if err != nil {
+			t.Logf("decodeCiphertext: %v", err)
+			return false
+		}
+		segment, err := sc.keyStream(off, len(plaintext))
+		if err != nil {
+			t.Logf("keyStream: %v", err)
+			return false
+		}
+		return bytes.Equal(xorBytes(cipherBytes, plaintext), segment)
+	}
+
+	if err := quick.Check(property, &quick.Config{MaxCount: 500}); err != nil {
+		t.Error(err)
+	}
+}
+
+// convertKeyToBytesReference is the original bit-at-a-time packing that
+// convertKeyToBytes must keep matching
+func convertKeyToBytesReference(key []int) []byte {
+	bytes := make([]byte, (len(key)+7)/8)
+	for i := 0; i < len(key); i++ {
+		if key[i] == 1 {
+			bytes[i/8] |= 1 << uint(7-i%8)
+		}
+	}
+	return bytes
+}
+
+func BenchmarkConvertKeyToBytes(b *testing.B) {
+	key := randomBits(rand.New(rand.NewSource(5)), 1<<20)
+	b.Run("reference", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			convertKeyToBytesReference(key)
+		}
+	})
+	b.Run("packed", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			convertKeyToBytes(key)
+		}
+	})
+}
+
+// FuzzConvertKeyToBytes checks that the packed implementation agrees with
+// the reference on any key, including lengths that are not whole bytes and
+// values other than 0 and 1, which both treat as unset
+func FuzzConvertKeyToBytes(f *testing.F) {
+	f.Add([]byte{})
+	f.Add([]byte{1})
+	f.Add([]byte{1, 0, 1, 1, 0, 0, 1, 0, 1})
+	f.Add([]byte{2, 1, 255, 1, 0, 1, 1, 1})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		key := make([]int, len(data))
+		for i, b := range data {
+			key[i] = int(b%4) - 1 // -1, 0, 1 or 2
+		}
+		if got, want := convertKeyToBytes(key), convertKeyToBytesReference(key); !bytes.Equal(got, want) {
+			t.Fatalf("convertKeyToBytes(%v) = %x, want %x", key, got, want)
+		}
+	})
+}
+
+// cleanDecryptError reports whether err is one of the errors DecryptMessage
+// documents for a bad message
+func cleanDecryptError(err error) bool {
+	for _, target := range []error{
+		ErrMalformedCiphertext, ErrCiphertextTooLarge, ErrMACMismatch, ErrUnknownMacAlgorithm,
+		ErrCascadeOpen, ErrWatermarkMismatch, ErrKeyRangeOutOfBounds, ErrDecompressedTooLarge,
+	} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// FuzzDecryptMessage feeds arbitrary ciphertexts, offsets, MACs and flags to
+// DecryptMessage and requires it to return cleanly. With authentic set the
+// MAC is computed over the fuzzed ciphertext, so the fuzzer also reaches the
+// cascade, watermark and decompression stages behind the MAC.
+func FuzzDecryptMessage(f *testing.F) {
+	sc := randomChannel(f, rand.New(rand.NewSource(6)), 256)
+	sc.CompressBeforeEncrypt = true
+	if msg, err := sc.EncryptMessage("hello, world", "alice"); err == nil {
+		f.Add(msg.Ciphertext, msg.Offset, msg.MAC, string(msg.MacAlgorithm), msg.Compressed, false, false, false)
+	}
+	f.Add("", 0, "", "", false, false, false, true)
+	f.Add("  AAAA==\n", 3, "AAAA", "poly1305", true, true, true, true)
+	f.Add("!!!not base64", -1, "", "hmac-sha512", false, false, false, true)
+	f.Add("AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA", 1<<40, "", "", false, true, false, true)
+
+	f.Fuzz(func(t *testing.T, ciphertext string, offset int, mac, algorithm string, compressed, cascade, watermark, authentic bool) {
+		msg := &Message{
+			Kind:         KindEncrypted,
+			Ciphertext:   ciphertext,
+			Sender:       "alice",
+			Offset:       offset,
+			MAC:          mac,
+			MacAlgorithm: MacAlgorithm(algorithm),
+			Compressed:   compressed,
+			Cascade:      cascade,
+			Watermark:    watermark,
+		}
+		if authentic {
+			cipherBytes, err := sc.decodeCiphertext(msg)
+			if err != nil {
+				return
+			}
+			flags := messageFlags{compressed, cascade, watermark, ""}
+			if msg.MAC, err = sc.computeMAC(msg.MacAlgorithm, offset, msg.Sender, flags, cipherBytes); err != nil {
+				return
+			}
+		}
+		if _, err := sc.DecryptMessage(msg); err != nil && !cleanDecryptError(err) {
+			t.Fatalf("DecryptMessage returned an unclassified error: %v", err)
+		}
+	})
+}
+
+// TestConcurrentDecrypt decrypts every message from many goroutines at once,
+// in different orders and while new messages are encrypted, and checks each
+// gets its own plaintext back. Run it with -race.
+func TestConcurrentDecrypt(t *testing.T) {
+	const (
+		messages = 200
+		workers  = 16
+	)
+	sc := randomChannel(t, rand.New(rand.NewSource(7)), 64*1024)
+	msgs := make([]*Message, messages)
+	for i := range msgs {
+		msg, err := sc.EncryptMessage(fmt.Sprintf("message %d", i), "alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+		msgs[i] = msg
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*messages+messages)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			for _, i := range rand.New(rand.NewSource(seed)).Perm(messages) {
+				plaintext, err := sc.DecryptMessage(msgs[i])
+				if err == nil && plaintext != fmt.Sprintf("message %d", i) {
+					err = fmt.Errorf("message %d decrypted to %q", i, plaintext)
+				}
+				if err != nil {
+					errs <- err
+				}
+			}
+		}(int64(w))
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < messages; i++ {
+			if _, err := sc.EncryptMessage("more traffic", "bob"); err != nil {
+				errs <- err
+			}
+		}
+	}()
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+// TestBase64PaddingRoundTrip encrypts with and without padding and checks
+// each message decrypts as emitted and after a client adds or strips the
+// padding, for lengths that need none, one or two '=' characters
+func TestBase64PaddingRoundTrip(t *testing.T) {
+	for _, unpadded := range []bool{false, true} {
+		sc := randomChannel(t, rand.New(rand.NewSource(8)), 1024)
+		sc.UnpaddedBase64 = unpadded
+		for _, plaintext := range []string{"a", "ab", "abc", "abcd", "hello, world"} {
+			msg, err := sc.EncryptMessage(plaintext, "alice")
+			if err != nil {
+				t.Fatal(err)
+			}
+			raw, err := sc.decodeCiphertext(msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			emitted := base64.StdEncoding
+			if unpadded {
+				emitted = base64.RawStdEncoding
+			}
+			if want := emitted.EncodeToString(raw); msg.Ciphertext != want {
+				t.Errorf("unpadded=%v: ciphertext %q, want %q", unpadded, msg.Ciphertext, want)
+			}
+			for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding} {
+				reencoded := *msg
+				reencoded.Ciphertext = enc.EncodeToString(raw)
+				reencoded.MAC = enc.EncodeToString(mustDecodeBase64(t, msg.MAC))
+				decrypted, err := sc.DecryptMessage(&reencoded)
+				if err != nil || decrypted != plaintext {
+					t.Errorf("unpadded=%v: %q re-encoded as %q decrypted to %q, %v", unpadded, plaintext, reencoded.Ciphertext, decrypted, err)
+				}
+			}
+		}
+	}
+}
+
+// mustDecodeBase64 decodes s with or without padding
+func mustDecodeBase64(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// TestEmptyPlaintextRejected checks that every encryption path refuses an
+// empty plaintext with ErrEmptyPlaintext, whatever the channel options,
+// without consuming key or storing a message
+func TestEmptyPlaintextRejected(t *testing.T) {
+	for _, setup := range []func(*SecureChannel){
+		func(*SecureChannel) {},
+		func(sc *SecureChannel) { sc.CompressBeforeEncrypt = true },
+		func(sc *SecureChannel) { sc.CascadeEncryption = true },
+		func(sc *SecureChannel) { sc.WatermarkCheck = true },
+	} {
+		sc := randomChannel(t, rand.New(rand.NewSource(11)), 256)
+		setup(sc)
+		remaining := sc.Remaining()
+
+		if _, err := sc.EncryptMessage("", "alice"); !errors.Is(err, ErrEmptyPlaintext) {
+			t.Errorf("EncryptMessage: %v, want ErrEmptyPlaintext", err)
+		}
+		if _, err := sc.EncryptAt("", 0); !errors.Is(err, ErrEmptyPlaintext) {
+			t.Errorf("EncryptAt: %v, want ErrEmptyPlaintext", err)
+		}
+		if got := sc.Remaining(); got != remaining {
+			t.Errorf("empty plaintext consumed %d key bytes", remaining-got)
+		}
+		if history := sc.History(); len(history) != 0 {
+			t.Errorf("empty plaintext stored %d messages", len(history))
+		}
+	}
+}
+
+// checkSorted reports an error unless msgs have strictly increasing
+// sequence numbers
+func checkSorted(msgs []Message) error {
+	for i := 1; i < len(msgs); i++ {
+		if msgs[i].Seq <= msgs[i-1].Seq {
+			return fmt.Errorf("seq %d follows seq %d", msgs[i].Seq, msgs[i-1].Seq)
+		}
+	}
+	return nil
+}
+
+// TestHistorySortedUnderConcurrentAppends appends encrypted and metadata
+// messages from many goroutines while others read History and Page, and
+// checks every snapshot and page is sorted by sequence number. Run it with
+// -race.
+func TestHistorySortedUnderConcurrentAppends(t *testing.T) {
+	const (
+		writers = 8
+		appends = 100
+		reads   = 200
+	)
+	for _, protected := range []bool{false, true} {
+		sc := randomChannel(t, rand.New(rand.NewSource(12)), 64*1024)
+		sc.MemoryProtection = protected
+
+		var writing, reading sync.WaitGroup
+		errs := make(chan error, 2*writers*appends)
+		for w := 0; w < writers; w++ {
+			writing.Add(1)
+			go func(w int) {
+				defer writing.Done()
+				for i := 0; i < appends; i++ {
+					if i%4 == 0 {
+						sc.RecordMeta(fmt.Sprint("writer", w), "typing")
+						continue
+					}
+					if _, err := sc.EncryptMessage(fmt.Sprint(w, i), fmt.Sprint("writer", w)); err != nil {
+						errs <- err
+					}
+				}
+			}(w)
+		}
+		for r := 0; r < 4; r++ {
+			reading.Add(1)
+			go func() {
+				defer reading.Done()
+				for i := 0; i < reads; i++ {
+					// A full snapshot gets expensive as the history grows
+					if i%16 == 0 {
+						if err := checkSorted(sc.History()); err != nil {
+							errs <- fmt.Errorf("History: %v", err)
+						}
+					}
+					page, _ := sc.Page(0, 10)
+					if err := checkSorted(page); err != nil {
+						errs <- fmt.Errorf("Page: %v", err)
+					}
+				}
+			}()
+		}
+		writing.Wait()
+		reading.Wait()
+		close(errs)
+		for err := range errs {
+			t.Errorf("protected=%v: %v", protected, err)
+		}
+
+		history := sc.History()
+		if len(history) != writers*appends {
+			t.Fatalf("protected=%v: %d messages stored, want %d", protected, len(history), writers*appends)
+		}
+		if err := checkSorted(history); err != nil {
+			t.Errorf("protected=%v: final history: %v", protected, err)
+		}
+		var paged []Message
+		for before := 0; ; {
+			page, next := sc.Page(before, 37)
+			paged = append(page, paged...)
+			if next == 0 {
+				break
+			}
+			before = next
+		}
+		if err := checkSorted(paged); err != nil || len(paged) != len(history) {
+			t.Errorf("protected=%v: paging back gave %d messages, %v", protected, len(paged), err)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+// TestCascadeRoundTrip checks that cascade messages decrypt, carry the
+// AES-GCM nonce and tag, and decrypt on a second channel over the same key
+func TestCascadeRoundTrip(t *testing.T) {
+	sc := randomChannel(t, rand.New(rand.NewSource(9)), 1024)
+	sc.CascadeEncryption = true
+	peer, err := NewSecureChannel(sc.SharedKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, plaintext := range []string{"x", "hello, world", string(make([]byte, 300))} {
+		msg, err := sc.EncryptMessage(plaintext, "alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !msg.Cascade {
+			t.Error("message is not marked as cascade")
+		}
+		sealed, err := sc.decodeCiphertext(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		aead, err := sc.cascadeAEAD()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := len(plaintext) + aead.NonceSize() + aead.Overhead(); len(sealed) != want {
+			t.Errorf("cascade ciphertext is %d bytes, want %d", len(sealed), want)
+		}
+
+		for _, ch := range []*SecureChannel{sc, peer} {
+			if decrypted, err := ch.DecryptMessage(msg); err != nil || decrypted != plaintext {
+				t.Errorf("DecryptMessage = %q, %v", decrypted, err)
+			}
+		}
+	}
+}
+
+// TestCascadeTampering checks that the cascade flag is authenticated and
+// that a damaged AES-GCM layer behind a valid MAC is refused
+func TestCascadeTampering(t *testing.T) {
+	sc := randomChannel(t, rand.New(rand.NewSource(10)), 1024)
+	sc.CascadeEncryption = true
+	msg, err := sc.EncryptMessage("hello, world", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stripped := *msg
+	stripped.Cascade = false
+	if _, err := sc.DecryptMessage(&stripped); !errors.Is(err, ErrMACMismatch) {
+		t.Errorf("clearing the cascade flag: %v, want ErrMACMismatch", err)
+	}
+
+	sealed, err := sc.decodeCiphertext(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed[len(sealed)-1] ^= 1
+	damaged := *msg
+	damaged.Ciphertext = sc.encoding().EncodeToString(sealed)
+	flags := messageFlags{msg.Compressed, msg.Cascade, msg.Watermark, msg.Room}
+	if damaged.MAC, err = sc.computeMAC(msg.MacAlgorithm, msg.Offset, msg.Sender, flags, sealed); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sc.DecryptMessage(&damaged); !errors.Is(err, ErrCascadeOpen) {
+		t.Errorf("damaged AES-GCM tag: %v, want ErrCascadeOpen", err)
+	}
+}
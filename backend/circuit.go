@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Gate names, matching OpenQASM's standard library
+const (
+	GateX       = "x"
+	GateH       = "h"
+	GateMeasure = "measure"
+)
+
+// GateOp is one operation applied to a qubit of the simulated run
+type GateOp struct {
+	Qubit   int    `json:"qubit"`
+	Gate    string `json:"gate"`
+	Party   string `json:"party"`             // Alice prepares, Bob measures
+	Outcome *int   `json:"outcome,omitempty"` // Bob's recorded result, for measurements only
+}
+
+// CircuitRepresentation describes the last run as a single-qubit circuit per
+// transmitted qubit. Alice prepares |0⟩, applies X for a 1 bit and then H for
+// the X basis; Bob applies H for the X basis before measuring in Z. Eve's
+// interception, when simulated, is not recorded and does not appear.
+func (bb84 *BB84Protocol) CircuitRepresentation() []GateOp {
+	ops := make([]GateOp, 0, 3*len(bb84.QuantumChannel))
+	for i := range bb84.QuantumChannel {
+		if bb84.Alice.bits[i] == 1 {
+			ops = append(ops, GateOp{Qubit: i, Gate: GateX, Party: bb84.Alice.name})
+		}
+		if bb84.Alice.bases[i] == XBasis {
+			ops = append(ops, GateOp{Qubit: i, Gate: GateH, Party: bb84.Alice.name})
+		}
+		if bb84.Bob.bases[i] == XBasis {
+			ops = append(ops, GateOp{Qubit: i, Gate: GateH, Party: bb84.Bob.name})
+		}
+		outcome := bb84.Bob.measuredBits[i]
+		ops = append(ops, GateOp{Qubit: i, Gate: GateMeasure, Party: bb84.Bob.name, Outcome: &outcome})
+	}
+	return ops
+}
+
+// Export the last run of a session as a list of gate operations
+func circuitHandler(c *gin.Context) {
+	session, ok := sessionFromRequest(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"qubits": session.Protocol.NumberOfBits,
+		"ops":    session.Protocol.CircuitRepresentation(),
+	})
+}
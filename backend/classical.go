@@ -0,0 +1,24 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// classicalExchange waits out rounds round trips of the configured
+// ClassicalLatency, standing in for the messages Alice and Bob exchange on
+// the authenticated classical channel. It returns early with ctx's error once
+// ctx is done.
+func (bb84 *BB84Protocol) classicalExchange(ctx context.Context, rounds int) error {
+	if bb84.ClassicalLatency <= 0 || rounds <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(time.Duration(rounds) * bb84.ClassicalLatency)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrRunNotCombinable is returned by CombineProtocols for a run whose key
+// cannot safely become part of a combined key
+var ErrRunNotCombinable = errors.New("run cannot be combined")
+
+// CombineProtocols concatenates the final keys of several completed runs,
+// in order, into one longer key for building up a one-time pad. Every run
+// must have finished without aborting and have had its QBER sampled and
+// found below its threshold. Passing the same run twice is rejected, since
+// its key bits would then be used in two places of the pad.
+func CombineProtocols(runs ...*BB84Protocol) ([]int, error) {
+	if len(runs) == 0 {
+		return nil, fmt.Errorf("%w: no runs given", ErrRunNotCombinable)
+	}
+
+	seen := make(map[*BB84Protocol]bool, len(runs))
+	total := 0
+	for i, run := range runs {
+		switch {
+		case seen[run]:
+			return nil, fmt.Errorf("%w: run %d repeats an earlier run", ErrRunNotCombinable, i)
+		case run.phase != PhaseComplete:
+			return nil, fmt.Errorf("%w: run %d has not completed", ErrRunNotCombinable, i)
+		case run.qber > run.QBERThreshold:
+			return nil, fmt.Errorf("%w: run %d was flagged for eavesdropping at qber %.4f", ErrRunNotCombinable, i, run.qber)
+		case run.SecureChannel == nil:
+			return nil, fmt.Errorf("%w: run %d was aborted", ErrRunNotCombinable, i)
+		case run.sampleSize == 0:
+			return nil, fmt.Errorf("%w: run %d was never checked for eavesdropping", ErrRunNotCombinable, i)
+		}
+		seen[run] = true
+		total += len(run.SharedKey)
+	}
+
+	key := make([]int, 0, total)
+	for _, run := range runs {
+		key = append(key, run.SharedKey...)
+	}
+	return key, nil
+}
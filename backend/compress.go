@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// compressionThreshold is the minimum response size worth compressing
+const compressionThreshold = 1024
+
+// bufferedResponseWriter holds the response body so it can be compressed
+// once the handler has finished
+type bufferedResponseWriter struct {
+	gin.ResponseWriter
+	buf    bytes.Buffer
+	status int
+}
+
+func (w *bufferedResponseWriter) WriteHeader(code int) { w.status = code }
+func (w *bufferedResponseWriter) WriteHeaderNow()      {}
+func (w *bufferedResponseWriter) Status() int          { return w.status }
+func (w *bufferedResponseWriter) Size() int            { return w.buf.Len() }
+func (w *bufferedResponseWriter) Written() bool        { return w.buf.Len() > 0 }
+
+func (w *bufferedResponseWriter) Write(data []byte) (int, error) {
+	return w.buf.Write(data)
+}
+
+func (w *bufferedResponseWriter) WriteString(s string) (int, error) {
+	return w.buf.WriteString(s)
+}
+
+// isWebSocketUpgrade reports whether the request asks for a protocol upgrade
+func isWebSocketUpgrade(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Upgrade"), "websocket") ||
+		strings.Contains(strings.ToLower(r.Header.Get("Connection")), "upgrade")
+}
+
+// negotiateEncoding picks gzip or deflate from an Accept-Encoding header
+func negotiateEncoding(acceptEncoding string) string {
+	accepted := map[string]bool{}
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		enabled := true
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if q, ok := strings.CutPrefix(param, "q="); ok {
+				if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+					enabled = false
+				}
+			}
+		}
+		accepted[name] = enabled
+	}
+
+	if accepted["gzip"] {
+		return "gzip"
+	}
+	if accepted["deflate"] {
+		return "deflate"
+	}
+	return ""
+}
+
+// compressResponses compresses GET responses larger than threshold bytes
+// using the encoding requested by the client
+func compressResponses(threshold int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method != http.MethodGet || isWebSocketUpgrade(c.Request) || isEventStream(c.Request) {
+			c.Next()
+			return
+		}
+		encoding := negotiateEncoding(c.GetHeader("Accept-Encoding"))
+		if encoding == "" {
+			c.Next()
+			return
+		}
+
+		original := c.Writer
+		buffered := &bufferedResponseWriter{ResponseWriter: original, status: http.StatusOK}
+		c.Writer = buffered
+		c.Next()
+		c.Writer = original
+
+		header := original.Header()
+		header.Add("Vary", "Accept-Encoding")
+		body := buffered.buf.Bytes()
+		if len(body) < threshold || header.Get("Content-Encoding") != "" {
+			original.WriteHeader(buffered.status)
+			original.Write(body)
+			return
+		}
+
+		var compressed bytes.Buffer
+		var encoder io.WriteCloser
+		if encoding == "gzip" {
+			encoder = gzip.NewWriter(&compressed)
+		} else {
+			encoder = zlib.NewWriter(&compressed)
+		}
+		encoder.Write(body)
+		encoder.Close()
+
+		header.Set("Content-Encoding", encoding)
+		header.Set("Content-Length", strconv.Itoa(compressed.Len()))
+		original.WriteHeader(buffered.status)
+		original.Write(compressed.Bytes())
+	}
+}
@@ -0,0 +1,473 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultProtocolTimeout bounds a protocol run when QCHAT_PROTOCOL_TIMEOUT is unset
+const defaultProtocolTimeout = time.Minute
+
+// Config holds server settings loaded from the environment
+type Config struct {
+	Mode         Mode
+	AuditLogPath string
+	CORSOrigins  []string
+	TLSCertFile  string
+	TLSKeyFile   string
+	GRPCAddr     string // Address of the gRPC service, such as :9090; empty disables it
+	AuthTokens   map[string]string
+	Channel      ChannelOptions
+	Protocol     ProtocolOptions
+	MaxBits      int
+	MaxSessions  int // Sessions kept before LRU eviction; 0 means unlimited
+
+	DuplicateSessions DuplicatePolicy // What initializing an existing session ID does
+
+	ProtocolTimeout time.Duration // Time budget for one protocol run; 0 means unlimited
+
+	DecryptAlertThreshold int
+	DecryptAlertWindow    time.Duration
+	DecryptAlertWebhook   string
+
+	EntropySampleBits int // Size of the startup RNG self-test; 0 disables it
+	RandRetries       int // Retries per random bit before a protocol run fails
+	AdminToken        string
+	SigningKeyPath    string // PKCS#8 Ed25519 key for signing /initialize responses
+	RandSourcePath    string // Entropy device used instead of crypto/rand, e.g. /dev/hwrng
+	JSONNaming        JSONNaming
+	PresharedKDF      KDF // Subkey derivation for imported keys
+	MaxSenderLength   int // Longest accepted sender name, in characters
+
+	Redaction    RedactionPolicy // Applied to RedactFields outside debug mode
+	RedactFields []string
+}
+
+// LoadConfig reads the server configuration from QCHAT_* environment variables
+func LoadConfig() (Config, error) {
+	mode, err := ParseMode(os.Getenv("QCHAT_ENV"))
+	if err != nil {
+		return Config{}, err
+	}
+
+	tokens, err := parseAuthTokens(os.Getenv("QCHAT_AUTH_TOKENS"))
+	if err != nil {
+		return Config{}, err
+	}
+
+	policy, err := parseKeyReusePolicy(os.Getenv("QCHAT_KEY_REUSE"))
+	if err != nil {
+		return Config{}, err
+	}
+
+	partition := false
+	if v := os.Getenv("QCHAT_PARTITION_KEY_BY_SENDER"); v != "" {
+		if partition, err = strconv.ParseBool(v); err != nil {
+			return Config{}, errors.New("QCHAT_PARTITION_KEY_BY_SENDER must be a boolean")
+		}
+	}
+
+	compress := false
+	if v := os.Getenv("QCHAT_COMPRESS_BEFORE_ENCRYPT"); v != "" {
+		if compress, err = strconv.ParseBool(v); err != nil {
+			return Config{}, errors.New("QCHAT_COMPRESS_BEFORE_ENCRYPT must be a boolean")
+		}
+	}
+
+	requireCheck := false
+	if v := os.Getenv("QCHAT_REQUIRE_QBER_CHECK"); v != "" {
+		if requireCheck, err = strconv.ParseBool(v); err != nil {
+			return Config{}, errors.New("QCHAT_REQUIRE_QBER_CHECK must be a boolean")
+		}
+	}
+
+	unpadded := false
+	if v := os.Getenv("QCHAT_UNPADDED_BASE64"); v != "" {
+		if unpadded, err = strconv.ParseBool(v); err != nil {
+			return Config{}, errors.New("QCHAT_UNPADDED_BASE64 must be a boolean")
+		}
+	}
+
+	cascade := false
+	if v := os.Getenv("QCHAT_CASCADE_ENCRYPTION"); v != "" {
+		if cascade, err = strconv.ParseBool(v); err != nil {
+			return Config{}, errors.New("QCHAT_CASCADE_ENCRYPTION must be a boolean")
+		}
+	}
+
+	memoryProtection := false
+	if v := os.Getenv("QCHAT_MEMORY_PROTECTION"); v != "" {
+		if memoryProtection, err = strconv.ParseBool(v); err != nil {
+			return Config{}, errors.New("QCHAT_MEMORY_PROTECTION must be a boolean")
+		}
+	}
+
+	var timingBucket time.Duration
+	if v := os.Getenv("QCHAT_TIMING_BUCKET"); v != "" {
+		if timingBucket, err = time.ParseDuration(v); err != nil || timingBucket < 0 {
+			return Config{}, errors.New("QCHAT_TIMING_BUCKET must be a non-negative duration")
+		}
+	}
+
+	maxPlaintext := 0
+	if v := os.Getenv("QCHAT_MAX_PLAINTEXT_BYTES"); v != "" {
+		if maxPlaintext, err = strconv.Atoi(v); err != nil || maxPlaintext < 0 {
+			return Config{}, errors.New("QCHAT_MAX_PLAINTEXT_BYTES must be a non-negative integer")
+		}
+	}
+
+	watermark := false
+	if v := os.Getenv("QCHAT_WATERMARK_CHECK"); v != "" {
+		if watermark, err = strconv.ParseBool(v); err != nil {
+			return Config{}, errors.New("QCHAT_WATERMARK_CHECK must be a boolean")
+		}
+	}
+
+	macAlgorithm, err := parseMacAlgorithm(os.Getenv("QCHAT_MAC_ALGORITHM"))
+	if err != nil {
+		return Config{}, err
+	}
+
+	extractor, err := parseExtractorType(os.Getenv("QCHAT_EXTRACTOR"))
+	if err != nil {
+		return Config{}, err
+	}
+	protocol := DefaultProtocolOptions()
+	protocol.Extractor = extractor
+	if protocol.PrepFlawProbability, err = parseProbability("QCHAT_PREP_FLAW_PROBABILITY"); err != nil {
+		return Config{}, err
+	}
+
+	if v := os.Getenv("QCHAT_MAX_RECONCILIATION_PASSES"); v != "" {
+		if protocol.MaxReconciliationPasses, err = strconv.Atoi(v); err != nil || protocol.MaxReconciliationPasses <= 0 {
+			return Config{}, errors.New("QCHAT_MAX_RECONCILIATION_PASSES must be a positive integer")
+		}
+	}
+
+	if protocol.Whitener, err = parseWhitenerType(os.Getenv("QCHAT_WHITENER")); err != nil {
+		return Config{}, err
+	}
+
+	if protocol.MeasurementTiming, err = parseMeasurementTiming(os.Getenv("QCHAT_MEASUREMENT_TIMING")); err != nil {
+		return Config{}, err
+	}
+
+	if v := os.Getenv("QCHAT_MIN_KEY_ENTROPY"); v != "" {
+		if protocol.MinKeyEntropy, err = strconv.ParseFloat(v, 64); err != nil || protocol.MinKeyEntropy < 0 || protocol.MinKeyEntropy > 8 {
+			return Config{}, errors.New("QCHAT_MIN_KEY_ENTROPY must be between 0 and 8 bits per byte")
+		}
+	}
+
+	if v := os.Getenv("QCHAT_TRANSMISSION_BATCHES"); v != "" {
+		if protocol.TransmissionBatches, err = strconv.Atoi(v); err != nil || protocol.TransmissionBatches <= 0 || protocol.TransmissionBatches > maxTransmissionBatches {
+			return Config{}, fmt.Errorf("QCHAT_TRANSMISSION_BATCHES must be an integer between 1 and %d", maxTransmissionBatches)
+		}
+	}
+
+	if v := os.Getenv("QCHAT_DEAD_TIME_SLOTS"); v != "" {
+		if protocol.DeadTimeSlots, err = strconv.Atoi(v); err != nil || protocol.DeadTimeSlots < 0 {
+			return Config{}, errors.New("QCHAT_DEAD_TIME_SLOTS must be a non-negative integer")
+		}
+	}
+
+	if v := os.Getenv("QCHAT_CLASSICAL_LATENCY"); v != "" {
+		if protocol.ClassicalLatency, err = time.ParseDuration(v); err != nil || protocol.ClassicalLatency < 0 {
+			return Config{}, errors.New("QCHAT_CLASSICAL_LATENCY must be a non-negative duration")
+		}
+	}
+
+	if v := os.Getenv("QCHAT_RANDOM_QBER_SAMPLE"); v != "" {
+		if protocol.RandomQBERSample, err = strconv.ParseBool(v); err != nil {
+			return Config{}, errors.New("QCHAT_RANDOM_QBER_SAMPLE must be a boolean")
+		}
+	}
+
+	if v := os.Getenv("QCHAT_MIN_SIFTING_EFFICIENCY"); v != "" {
+		if protocol.MinSiftingEfficiency, err = strconv.ParseFloat(v, 64); err != nil || protocol.MinSiftingEfficiency < 0 || protocol.MinSiftingEfficiency > 0.5 {
+			return Config{}, errors.New("QCHAT_MIN_SIFTING_EFFICIENCY must be between 0 and 0.5")
+		}
+	}
+
+	maxSender := DefaultMaxSenderLength
+	if v := os.Getenv("QCHAT_MAX_SENDER_LENGTH"); v != "" {
+		if maxSender, err = strconv.Atoi(v); err != nil || maxSender <= 0 {
+			return Config{}, errors.New("QCHAT_MAX_SENDER_LENGTH must be a positive integer")
+		}
+	}
+
+	maxBits := computeMaxBits()
+	if v := os.Getenv("QCHAT_MAX_BITS"); v != "" {
+		if maxBits, err = strconv.Atoi(v); err != nil || maxBits <= 0 {
+			return Config{}, errors.New("QCHAT_MAX_BITS must be a positive integer")
+		}
+	}
+
+	maxSessions := 0
+	if v := os.Getenv("QCHAT_MAX_SESSIONS"); v != "" {
+		if maxSessions, err = strconv.Atoi(v); err != nil || maxSessions < 0 {
+			return Config{}, errors.New("QCHAT_MAX_SESSIONS must be a non-negative integer")
+		}
+	}
+
+	protocolTimeout := defaultProtocolTimeout
+	if v := os.Getenv("QCHAT_PROTOCOL_TIMEOUT"); v != "" {
+		if protocolTimeout, err = time.ParseDuration(v); err != nil || protocolTimeout < 0 {
+			return Config{}, errors.New("QCHAT_PROTOCOL_TIMEOUT must be a non-negative duration")
+		}
+	}
+
+	alertThreshold := 0
+	if v := os.Getenv("QCHAT_DECRYPT_ALERT_THRESHOLD"); v != "" {
+		if alertThreshold, err = strconv.Atoi(v); err != nil || alertThreshold < 0 {
+			return Config{}, errors.New("QCHAT_DECRYPT_ALERT_THRESHOLD must be a non-negative integer")
+		}
+	}
+	alertWindow := time.Minute
+	if v := os.Getenv("QCHAT_DECRYPT_ALERT_WINDOW"); v != "" {
+		if alertWindow, err = time.ParseDuration(v); err != nil || alertWindow <= 0 {
+			return Config{}, errors.New("QCHAT_DECRYPT_ALERT_WINDOW must be a positive duration")
+		}
+	}
+
+	entropyBits := defaultEntropySampleBits
+	if v := os.Getenv("QCHAT_ENTROPY_SAMPLE_BITS"); v != "" {
+		if entropyBits, err = strconv.Atoi(v); err != nil || entropyBits < 0 {
+			return Config{}, errors.New("QCHAT_ENTROPY_SAMPLE_BITS must be a non-negative integer")
+		}
+	}
+
+	randRetries := DefaultRandRetries
+	if v := os.Getenv("QCHAT_RAND_RETRIES"); v != "" {
+		if randRetries, err = strconv.Atoi(v); err != nil || randRetries < 0 {
+			return Config{}, errors.New("QCHAT_RAND_RETRIES must be a non-negative integer")
+		}
+	}
+
+	naming, err := parseJSONNaming(os.Getenv("QCHAT_JSON_NAMING"))
+	if err != nil {
+		return Config{}, err
+	}
+
+	presharedKDF, err := parseKDF(os.Getenv("QCHAT_PRESHARED_KDF"))
+	if err != nil {
+		return Config{}, err
+	}
+
+	duplicates, err := parseDuplicatePolicy(os.Getenv("QCHAT_DUPLICATE_SESSIONS"))
+	if err != nil {
+		return Config{}, err
+	}
+
+	redaction, err := parseRedactionPolicy(os.Getenv("QCHAT_REDACTION"))
+	if err != nil {
+		return Config{}, err
+	}
+	redactFields := DefaultRedactFields
+	if v := os.Getenv("QCHAT_REDACT_FIELDS"); v != "" {
+		redactFields = splitList(v)
+	}
+
+	channel := ChannelOptions{
+		ReusePolicy:          policy,
+		MacAlgorithm:         macAlgorithm,
+		PartitionKeyBySender: partition,
+		TimingBucket:         timingBucket,
+
+		CompressBeforeEncrypt: compress,
+		RequireQBERCheck:      requireCheck,
+		UnpaddedBase64:        unpadded,
+		CascadeEncryption:     cascade,
+		MemoryProtection:      memoryProtection,
+		MaxPlaintextBytes:     maxPlaintext,
+		WatermarkCheck:        watermark,
+	}
+
+	cfg := Config{
+		Mode:         mode,
+		AuditLogPath: os.Getenv("QCHAT_AUDIT_LOG"),
+		CORSOrigins:  splitList(os.Getenv("QCHAT_CORS_ORIGINS")),
+		TLSCertFile:  os.Getenv("QCHAT_TLS_CERT"),
+		TLSKeyFile:   os.Getenv("QCHAT_TLS_KEY"),
+		GRPCAddr:     os.Getenv("QCHAT_GRPC_ADDR"),
+		AuthTokens:   tokens,
+		Channel:      channel,
+		Protocol:     protocol,
+		MaxBits:      maxBits,
+		MaxSessions:  maxSessions,
+
+		DuplicateSessions: duplicates,
+
+		ProtocolTimeout: protocolTimeout,
+
+		DecryptAlertThreshold: alertThreshold,
+		DecryptAlertWindow:    alertWindow,
+		DecryptAlertWebhook:   os.Getenv("QCHAT_DECRYPT_ALERT_WEBHOOK"),
+
+		EntropySampleBits: entropyBits,
+		RandRetries:       randRetries,
+		AdminToken:        os.Getenv("QCHAT_ADMIN_TOKEN"),
+		SigningKeyPath:    os.Getenv("QCHAT_SIGNING_KEY"),
+		RandSourcePath:    os.Getenv("QCHAT_RAND_SOURCE"),
+		JSONNaming:        naming,
+		PresharedKDF:      presharedKDF,
+		MaxSenderLength:   maxSender,
+
+		Redaction:    redaction,
+		RedactFields: redactFields,
+	}
+	return cfg, cfg.Validate()
+}
+
+// Validate checks that the configuration is safe for its mode
+func (cfg Config) Validate() error {
+	if !cfg.Mode.AllowsWildcardCORS() {
+		if len(cfg.CORSOrigins) == 0 {
+			return errors.New("QCHAT_CORS_ORIGINS must be set in production")
+		}
+		for _, origin := range cfg.CORSOrigins {
+			if origin == "*" {
+				return errors.New("wildcard CORS origin is not allowed in production")
+			}
+		}
+	}
+	if cfg.Channel.PartitionKeyBySender && cfg.Channel.ReusePolicy == PolicyRepeat {
+		return errors.New("QCHAT_PARTITION_KEY_BY_SENDER cannot be combined with QCHAT_KEY_REUSE=repeat")
+	}
+	if cfg.Mode.RequiresTLS() && (cfg.TLSCertFile == "" || cfg.TLSKeyFile == "") {
+		return errors.New("QCHAT_TLS_CERT and QCHAT_TLS_KEY must be set in production")
+	}
+	return nil
+}
+
+// CheckResources opens the files the configuration refers to, the TLS key
+// pair, signing key, audit log and random source, without starting the
+// server, so a deployment can fail fast on a path that would only break at
+// startup or on the first audited event
+func (cfg Config) CheckResources() error {
+	if cfg.TLSCertFile != "" || cfg.TLSKeyFile != "" {
+		if _, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile); err != nil {
+			return fmt.Errorf("TLS key pair: %v", err)
+		}
+	}
+	if cfg.SigningKeyPath != "" {
+		if _, err := LoadSigningKey(cfg.SigningKeyPath); err != nil {
+			return fmt.Errorf("signing key: %v", err)
+		}
+	}
+	if cfg.AuditLogPath != "" {
+		logger, err := NewFileAuditLogger(cfg.AuditLogPath)
+		if err != nil {
+			return err
+		}
+		logger.Close()
+	}
+	if cfg.RandSourcePath != "" {
+		if _, err := OpenDeviceRandSource(cfg.RandSourcePath); err != nil {
+			return fmt.Errorf("random source: %v", err)
+		}
+	}
+	return nil
+}
+
+// parseKeyReusePolicy parses QCHAT_KEY_REUSE, defaulting to strict
+func parseKeyReusePolicy(s string) (KeyReusePolicy, error) {
+	switch s {
+	case "", "strict":
+		return PolicyStrict, nil
+	case "repeat":
+		return PolicyRepeat, nil
+	default:
+		return 0, fmt.Errorf("unknown QCHAT_KEY_REUSE %q", s)
+	}
+}
+
+// parseMacAlgorithm parses QCHAT_MAC_ALGORITHM, defaulting to HMAC-SHA256
+func parseMacAlgorithm(s string) (MacAlgorithm, error) {
+	if s == "" {
+		return MacHMACSHA256, nil
+	}
+	if _, err := newAuthenticator(MacAlgorithm(s)); err != nil {
+		return "", fmt.Errorf("unknown QCHAT_MAC_ALGORITHM %q", s)
+	}
+	return MacAlgorithm(s), nil
+}
+
+// parseExtractorType parses QCHAT_EXTRACTOR, defaulting to Toeplitz hashing
+func parseExtractorType(s string) (ExtractorType, error) {
+	switch s {
+	case "", "toeplitz":
+		return ExtractorToeplitz, nil
+	case "sha256":
+		return ExtractorSHA256, nil
+	default:
+		return 0, fmt.Errorf("unknown QCHAT_EXTRACTOR %q", s)
+	}
+}
+
+// parseWhitenerType parses QCHAT_WHITENER, defaulting to no whitening
+func parseWhitenerType(s string) (WhitenerType, error) {
+	switch s {
+	case "", "none":
+		return WhitenerNone, nil
+	case "von-neumann":
+		return WhitenerVonNeumann, nil
+	case "sha256-mix":
+		return WhitenerSHA256Mix, nil
+	default:
+		return 0, fmt.Errorf("unknown QCHAT_WHITENER %q", s)
+	}
+}
+
+// parseKDF parses QCHAT_PRESHARED_KDF, defaulting to Argon2id
+func parseKDF(s string) (KDF, error) {
+	switch s {
+	case "", "argon2", "argon2id":
+		return KDFArgon2, nil
+	case "hkdf":
+		return KDFHKDF, nil
+	default:
+		return 0, fmt.Errorf("unknown QCHAT_PRESHARED_KDF %q", s)
+	}
+}
+
+// parseMeasurementTiming parses QCHAT_MEASUREMENT_TIMING, defaulting to
+// announcing bases only after measurement
+func parseMeasurementTiming(s string) (MeasurementTiming, error) {
+	switch s {
+	case "", "measure-first":
+		return MeasureThenAnnounce, nil
+	case "announce-first":
+		return AnnounceThenMeasure, nil
+	default:
+		return 0, fmt.Errorf("unknown QCHAT_MEASUREMENT_TIMING %q", s)
+	}
+}
+
+// parseProbability reads an optional probability in [0, 1] from the environment
+func parseProbability(name string) (float64, error) {
+	s := os.Getenv(name)
+	if s == "" {
+		return 0, nil
+	}
+	p, err := strconv.ParseFloat(s, 64)
+	if err != nil || p < 0 || p > 1 {
+		return 0, fmt.Errorf("%s must be a probability between 0 and 1", name)
+	}
+	return p, nil
+}
+
+// splitList parses a comma-separated list, dropping empty entries
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
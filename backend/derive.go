@@ -0,0 +1,56 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// sessionKeyLabel domain-separates keys derived by DeriveSessionKeys from
+// each other and from the channel subkeys
+const sessionKeyLabel = "qchat derived session key"
+
+// maxDerivedKeyBits is the most HKDF-SHA256 can expand a single key to
+const maxDerivedKeyBits = 255 * sha256.Size * 8
+
+// ErrInsufficientEntropy is returned when the requested keys would together
+// hold more bits than the run's final key
+var ErrInsufficientEntropy = errors.New("not enough key entropy")
+
+// DeriveSessionKeys expands the final key of a completed run into n keys of
+// lenEach bits each, so one protocol run can seed several secure channels.
+// Key i comes from HKDF-SHA256 with its index in the info string, so the
+// keys are independent of each other as far as any efficient adversary can
+// tell. Their total length may not exceed the final key, which bounds the
+// entropy they draw on. They share that entropy with the run's own channel,
+// which should not also be used.
+func (bb84 *BB84Protocol) DeriveSessionKeys(n, lenEach int) ([][]int, error) {
+	if bb84.phase != PhaseComplete || bb84.SecureChannel == nil {
+		return nil, fmt.Errorf("%w: keys can only be derived from a completed run", ErrInvalidPhase)
+	}
+	if n <= 0 || lenEach <= 0 || lenEach > maxDerivedKeyBits {
+		return nil, fmt.Errorf("%w: need a positive count and a length between 1 and %d bits", ErrKeyRangeOutOfBounds, maxDerivedKeyBits)
+	}
+	if n > len(bb84.SharedKey)/lenEach {
+		return nil, fmt.Errorf("%w: %d keys of %d bits requested, final key has %d bits",
+			ErrInsufficientEntropy, n, lenEach, len(bb84.SharedKey))
+	}
+
+	secret := convertKeyToBytes(bb84.SharedKey)
+	defer clear(secret)
+	keys := make([][]int, n)
+	for i := range keys {
+		info := binary.BigEndian.AppendUint64([]byte(sessionKeyLabel), uint64(i))
+		derived := make([]byte, (lenEach+7)/8)
+		if _, err := io.ReadFull(hkdf.New(sha256.New, secret, nil, info), derived); err != nil {
+			return nil, err
+		}
+		keys[i] = bytesToKey(derived)[:lenEach]
+		clear(derived)
+	}
+	return keys, nil
+}
@@ -0,0 +1,27 @@
+package main
+
+// deadTimeMisses returns which qubits Bob's detectors miss when the detector
+// for a basis stays dead for slots qubit slots after each detection in that
+// basis. Qubits measured in the other basis are still detected, so losses
+// come in bursts that depend on Bob's basis sequence. It returns nil when
+// slots is zero, as every qubit is then detected.
+func deadTimeMisses(bases []Basis, slots int) []bool {
+	if slots <= 0 {
+		return nil
+	}
+	missed := make([]bool, len(bases))
+	var recovers [2]int // First slot at which each basis's detector can click again
+	for i, basis := range bases {
+		if i < recovers[basis] {
+			missed[i] = true
+			continue
+		}
+		recovers[basis] = i + 1 + slots
+	}
+	return missed
+}
+
+// detected reports whether Bob's detector registered qubit i
+func (bb84 *BB84Protocol) detected(i int) bool {
+	return bb84.Bob.missed == nil || !bb84.Bob.missed[i]
+}
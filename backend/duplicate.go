@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// DuplicatePolicy selects what initializing or importing a key under an
+// existing session ID does
+type DuplicatePolicy int
+
+const (
+	DuplicateReset  DuplicatePolicy = iota // Replace the session and its key; the default
+	DuplicateReject                        // Refuse with ErrSessionExists
+	DuplicateAppend                        // Append the new key to the session's channel
+)
+
+// Errors returned when a session ID is reused
+var (
+	ErrSessionExists    = errors.New("session already exists")
+	ErrKeyNotExtendable = errors.New("key cannot be extended")
+)
+
+// parseDuplicatePolicy parses QCHAT_DUPLICATE_SESSIONS, defaulting to reset
+func parseDuplicatePolicy(s string) (DuplicatePolicy, error) {
+	switch s {
+	case "", "reset":
+		return DuplicateReset, nil
+	case "reject":
+		return DuplicateReject, nil
+	case "append":
+		return DuplicateAppend, nil
+	default:
+		return 0, fmt.Errorf("unknown QCHAT_DUPLICATE_SESSIONS %q", s)
+	}
+}
+
+// appendKey extends the channel's key with next's key, so messages and
+// offsets carry over to the longer pad. The MAC and cascade subkeys stay
+// derived from the original key, which keeps stored messages verifiable.
+// The appended bits start on a byte boundary; any padding bits of the old
+// last byte are kept as zeros in SharedKey so it still packs to keyBytes.
+// It returns the combined key.
+func (sc *SecureChannel) appendKey(next *SecureChannel) ([]int, error) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	switch {
+	case sc.quarantined:
+		return nil, ErrSessionQuarantined
+	case len(sc.keyBytes) == 0:
+		return nil, fmt.Errorf("%w: the key was wiped", ErrKeyNotExtendable)
+	case sc.PartitionKeyBySender:
+		return nil, fmt.Errorf("%w: %v", ErrKeyNotExtendable, ErrKeyPartitioned)
+	case sc.offset > len(sc.keyBytes):
+		// Offsets past the end wrapped around under PolicyRepeat, and a
+		// longer key would make their messages undecryptable
+		return nil, fmt.Errorf("%w: the key has already been reused", ErrKeyNotExtendable)
+	}
+
+	padding := len(sc.keyBytes)*8 - len(sc.SharedKey)
+	sc.SharedKey = append(append(sc.SharedKey, make([]int, padding)...), next.SharedKey...)
+	sc.keyBytes = append(sc.keyBytes, next.keyBytes...)
+	sc.checked = sc.checked && next.checked
+	return sc.SharedKey, nil
+}
+
+// extend moves a newly keyed session onto previous's channel with its key
+// appended, keeping the earlier messages, offsets, options and audit log.
+// The new session's own channel is wiped.
+func (s *Session) extend(previous *Session) error {
+	key, err := previous.Channel.appendKey(s.Channel)
+	if err != nil {
+		return err
+	}
+	s.Channel.wipe()
+	s.Protocol.SharedKey = key
+	s.Protocol.SecureChannel = previous.Channel
+	s.Channel = previous.Channel
+	s.Imported = s.Imported || previous.Imported
+	s.CreatedAt = previous.CreatedAt
+	s.audit = previous.audit
+	return nil
+}
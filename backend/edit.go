@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Errors returned by EditMessage
+var (
+	ErrMessageNotFound   = errors.New("message not found")
+	ErrMessageSuperseded = errors.New("message already superseded")
+	ErrNotMessageSender  = errors.New("only the original sender can edit a message")
+)
+
+// EditMessage encrypts a replacement for the message with the given sequence
+// number at a fresh key offset and links the two. The original ciphertext
+// is kept and flagged as superseded; its key bytes are never reused. The
+// edit stays in the original's room.
+func (sc *SecureChannel) EditMessage(seq int, plaintext, sender string) (*Message, error) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	i := sort.Search(sc.messageCount(), func(i int) bool { return sc.messageSeq(i) >= seq })
+	if i == sc.messageCount() || sc.messageSeq(i) != seq {
+		return nil, fmt.Errorf("%w: %d", ErrMessageNotFound, seq)
+	}
+	original := sc.messageAt(i)
+	if original.Kind != KindEncrypted {
+		return nil, fmt.Errorf("%w: %d", ErrMessageNotFound, seq)
+	}
+	if original.SupersededBy != 0 {
+		return nil, fmt.Errorf("%w: edit message %d instead", ErrMessageSuperseded, original.SupersededBy)
+	}
+	if original.Sender != sender {
+		return nil, ErrNotMessageSender
+	}
+
+	msg, err := sc.encryptNext(plaintext, sender, original.Room, seq)
+	if err != nil {
+		return nil, err
+	}
+	original.SupersededBy = msg.Seq
+	sc.putMessage(i, original)
+	return msg, nil
+}
+
+// Replace a message with an edited version encrypted at a fresh offset
+func editMessageHandler(c *gin.Context) {
+	seq, err := strconv.Atoi(c.Param("id"))
+	var req EncryptRequest
+	if err != nil || c.ShouldBindJSON(&req) != nil {
+		respondError(c, http.StatusBadRequest, "Invalid request format")
+		return
+	}
+
+	session, ok := sessionFromRequest(c)
+	if !ok {
+		return
+	}
+
+	sender, ok := resolveSender(c, req.Sender)
+	if !ok {
+		return
+	}
+
+	plaintext, ok := requestPlaintext(c, req)
+	if !ok {
+		return
+	}
+
+	msg, err := session.Channel.EditMessage(seq, plaintext, sender)
+	switch {
+	case errors.Is(err, ErrSessionQuarantined):
+		respondError(c, http.StatusLocked, "Session is quarantined")
+	case errors.Is(err, ErrQBERNotChecked):
+		respondError(c, http.StatusConflict, "Key was never checked for eavesdropping")
+	case errors.Is(err, ErrMessageNotFound):
+		respondError(c, http.StatusNotFound, "Message not found")
+	case errors.Is(err, ErrMessageSuperseded):
+		respondError(c, http.StatusConflict, err.Error())
+	case errors.Is(err, ErrEmptyPlaintext):
+		respondError(c, http.StatusBadRequest, "Plaintext must not be empty")
+	case errors.Is(err, ErrMessageTooLong):
+		respondError(c, http.StatusRequestEntityTooLarge, err.Error())
+	case errors.Is(err, ErrNotMessageSender):
+		respondError(c, http.StatusForbidden, "Only the original sender can edit a message")
+	case errors.Is(err, ErrKeyExhausted):
+		respondError(c, http.StatusConflict, err.Error())
+	case err != nil:
+		respondError(c, http.StatusInternalServerError, "Failed to encrypt message")
+	default:
+		c.JSON(http.StatusOK, gin.H{"seq": msg.Seq, "supersedes": seq, "ciphertext": msg.Ciphertext})
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// EncryptionMode names the layers applied to each message of a session
+type EncryptionMode string
+
+const (
+	ModeOTP       EncryptionMode = "otp"         // One-time pad only
+	ModeOTPAESGCM EncryptionMode = "otp+aes-gcm" // One-time pad sealed again with AES-GCM
+)
+
+// minCascadeSecureBits is the key a cascade session must be expected to
+// distill, so its derived AES-256 key carries a full 256 bits of entropy
+const minCascadeSecureBits = 256
+
+// ErrInvalidEncryptionConfig is returned for an encryptionConfig naming an
+// unknown mode or MAC algorithm
+var ErrInvalidEncryptionConfig = errors.New("invalid encryption config")
+
+// EncryptionConfig is a session's choice of encryption, negotiated at
+// /initialize. Unset fields keep the server's configured defaults.
+type EncryptionConfig struct {
+	Mode         EncryptionMode `json:"mode"`
+	MacAlgorithm MacAlgorithm   `json:"macAlgorithm"`
+	Compress     *bool          `json:"compress"`
+}
+
+// apply returns base with the config's choices applied
+func (e *EncryptionConfig) apply(base ChannelOptions) (ChannelOptions, error) {
+	if e == nil {
+		return base, nil
+	}
+
+	switch e.Mode {
+	case "":
+	case ModeOTP:
+		base.CascadeEncryption = false
+	case ModeOTPAESGCM:
+		base.CascadeEncryption = true
+	default:
+		return ChannelOptions{}, fmt.Errorf("%w: unknown mode %q", ErrInvalidEncryptionConfig, e.Mode)
+	}
+	if e.MacAlgorithm != "" {
+		if _, err := newAuthenticator(e.MacAlgorithm); err != nil {
+			return ChannelOptions{}, fmt.Errorf("%w: %v", ErrInvalidEncryptionConfig, err)
+		}
+		base.MacAlgorithm = e.MacAlgorithm
+	}
+	if e.Compress != nil {
+		base.CompressBeforeEncrypt = *e.Compress
+	}
+	return base, nil
+}
+
+// encryptionMode reports the mode the options select
+func (o ChannelOptions) encryptionMode() EncryptionMode {
+	if o.CascadeEncryption {
+		return ModeOTPAESGCM
+	}
+	return ModeOTP
+}
+
+// validEncryptionConfig resolves the channel options for a new session,
+// writing an error response if the config is invalid or asks for cascade
+// mode from too few bits to expect a full-strength AES key
+func validEncryptionConfig(c *gin.Context, config *EncryptionConfig, name ProtocolName, bits int) (ChannelOptions, bool) {
+	opts, err := config.apply(channelOptions)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return ChannelOptions{}, false
+	}
+
+	if config != nil && opts.CascadeEncryption {
+		info, _ := lookupProtocol(name)
+		needed, err := estimateRawBits(info, protocolOptions, minCascadeSecureBits, 0)
+		if err == nil && bits < needed.RawBits {
+			respondError(c, http.StatusBadRequest, fmt.Sprintf("mode %s needs at least %d bits", ModeOTPAESGCM, needed.RawBits))
+			return ChannelOptions{}, false
+		}
+	}
+	return opts, true
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// siftingMarginSigmas is how many standard deviations of the binomial sifting
+// yield the raw-bit estimate allows for, so about 99.9% of runs sift enough
+const siftingMarginSigmas = 3
+
+// BitEstimate is the raw bit count recommended for a target secure key length
+type BitEstimate struct {
+	Protocol       ProtocolName `json:"protocol"`
+	SecureBits     int          `json:"secureBits"`
+	Qber           float64      `json:"qber"`
+	SiftingYield   float64      `json:"siftingYield"`
+	SiftedBits     int          `json:"siftedBits"` // Needed after sifting, before QBER sampling
+	RawBits        int          `json:"rawBits"`    // Recommended request, including the sifting margin
+	ExceedsMaxBits bool         `json:"exceedsMaxBits"`
+}
+
+// estimateRawBits inverts privacy amplification, QBER sampling and sifting
+// to find how many raw bits yield secureBits at the given QBER. It returns
+// an error when no key length can reach the target.
+func estimateRawBits(info ProtocolInfo, opts ProtocolOptions, secureBits int, qber float64) (BitEstimate, error) {
+	if qber > opts.QBERThreshold {
+		return BitEstimate{}, fmt.Errorf("qber %.4f exceeds the abort threshold %.4f", qber, opts.QBERThreshold)
+	}
+	rate := 1 - 2*binaryEntropy(qber)
+	if rate <= 0 {
+		return BitEstimate{}, fmt.Errorf("no secure key can be distilled at qber %.4f", qber)
+	}
+
+	// Bits left after sampling; rounding in the leak estimate may need a few more
+	kept := int(math.Ceil(float64(secureBits) / rate))
+	for secureKeyLength(kept, qber) < secureBits {
+		kept++
+	}
+
+	f := opts.QBERSampleFraction
+	sifted := int(math.Ceil(float64(kept) / (1 - f)))
+	for sifted-int(math.Ceil(float64(sifted)*f)) < kept {
+		sifted++
+	}
+
+	// Smallest n with n·y - k·sqrt(n·y·(1-y)) >= sifted, solved for sqrt(n)
+	y := info.SiftingYield
+	b := siftingMarginSigmas * math.Sqrt(y*(1-y))
+	x := (b + math.Sqrt(b*b+4*y*float64(sifted))) / (2 * y)
+	raw := int(math.Ceil(x * x))
+
+	return BitEstimate{
+		Protocol:       info.Name,
+		SecureBits:     secureBits,
+		Qber:           qber,
+		SiftingYield:   y,
+		SiftedBits:     sifted,
+		RawBits:        raw,
+		ExceedsMaxBits: raw > maxBits,
+	}, nil
+}
+
+// Recommend a raw bit count for POST /initialize that yields secureBits of
+// secure key at the expected qber
+func estimateBitsHandler(c *gin.Context) {
+	secureBits, err := strconv.Atoi(c.Query("secureBits"))
+	if err != nil || secureBits <= 0 {
+		respondError(c, http.StatusBadRequest, "secureBits must be a positive integer")
+		return
+	}
+	qber, err := strconv.ParseFloat(c.DefaultQuery("qber", "0"), 64)
+	if err != nil || qber < 0 || qber >= 0.5 {
+		respondError(c, http.StatusBadRequest, "qber must be a number in [0, 0.5)")
+		return
+	}
+	name := ProtocolName(c.Query("protocol"))
+	if !validProtocol(c, name) {
+		return
+	}
+
+	info, _ := lookupProtocol(name)
+	estimate, err := estimateRawBits(info, protocolOptions, secureBits, qber)
+	if err != nil {
+		respondError(c, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, estimate)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fingerprintLabel domain-separates key fingerprints from other hashes
+const fingerprintLabel = "qchat key fingerprint"
+
+// keyFingerprint identifies a key without revealing it. It hashes the derived
+// MAC key rather than the key itself, so brute-forcing a preshared key from
+// its fingerprint costs as much as from a MAC.
+func keyFingerprint(macKey []byte) string {
+	sum := sha256.Sum256(append([]byte(fingerprintLabel), macKey...))
+	return hex.EncodeToString(sum[:16])
+}
+
+// Fingerprint returns the key fingerprint, which survives wiping the key
+func (sc *SecureChannel) Fingerprint() string {
+	return sc.fingerprint
+}
+
+// protocolParameters reports the options a run used, with the same names
+// and value forms as the rest of the API
+func protocolParameters(o ProtocolOptions) gin.H {
+	return gin.H{
+		"qberSampleFraction":      o.QBERSampleFraction,
+		"qberThreshold":           o.QBERThreshold,
+		"extractor":               o.Extractor.String(),
+		"prepFlawProbability":     o.PrepFlawProbability,
+		"injectedErrorRate":       o.InjectedErrorRate,
+		"interceptResend":         o.InterceptResend,
+		"maxReconciliationPasses": o.MaxReconciliationPasses,
+		"measurementTiming":       o.MeasurementTiming.String(),
+		"minKeyEntropy":           o.MinKeyEntropy,
+		"deadTimeSlots":           o.DeadTimeSlots,
+		"targetSiftedBits":        o.TargetSiftedBits,
+		"classicalLatency":        o.ClassicalLatency.String(),
+		"whitener":                o.Whitener.String(),
+		"randomQberSample":        o.RandomQBERSample,
+		"minSiftingEfficiency":    o.MinSiftingEfficiency,
+	}
+}
+
+// channelParameters reports the options a session's channel encrypts with
+func channelParameters(sc *SecureChannel) gin.H {
+	o := sc.ChannelOptions
+	return gin.H{
+		"reusePolicy":           o.ReusePolicy.String(),
+		"macAlgorithm":          sc.macAlgorithm(),
+		"encryptionMode":        o.encryptionMode(),
+		"partitionKeyBySender":  o.PartitionKeyBySender,
+		"timingBucket":          o.TimingBucket.String(),
+		"compressBeforeEncrypt": o.CompressBeforeEncrypt,
+		"requireQberCheck":      o.RequireQBERCheck,
+		"unpaddedBase64":        o.UnpaddedBase64,
+		"memoryProtection":      o.MemoryProtection,
+		"maxPlaintextBytes":     o.MaxPlaintextBytes,
+		"watermarkCheck":        o.WatermarkCheck,
+	}
+}
+
+// Dump everything known about a session for post-incident analysis, signed
+// like other responses when a signing key is configured. Raw key bytes are
+// never included; the key is identified by its fingerprint.
+func forensicDumpHandler(c *gin.Context) {
+	session, ok := sessionFromRequest(c)
+	if !ok {
+		return
+	}
+
+	channel := session.Channel
+	offsets := gin.H{
+		"offset":         channel.Offset(),
+		"remainingBytes": max(channel.Remaining(), 0),
+		"keyBytes":       (len(session.Protocol.SharedKey) + 7) / 8,
+	}
+	if channel.PartitionKeyBySender {
+		offsets["partitionOffsets"] = channel.PartitionOffsets()
+	}
+
+	var audit []AuditEvent
+	if session.audit != nil {
+		audit = session.audit.Events()
+	}
+
+	body := gin.H{
+		"sessionId":       session.ID,
+		"generatedAt":     time.Now().UTC(),
+		"protocol":        session.Protocol.Name(),
+		"imported":        session.Imported,
+		"createdAt":       session.CreatedAt,
+		"quarantined":     channel.Quarantined(),
+		"fingerprint":     channel.Fingerprint(),
+		"numberOfBits":    session.Protocol.NumberOfBits,
+		"protocolOptions": protocolParameters(session.Protocol.ProtocolOptions),
+		"channelOptions":  channelParameters(channel),
+		"result":          session.Result,
+		"qberHistory":     session.QBERHistory(),
+		"offsets":         offsets,
+		"messages":        channel.History(),
+		"audit":           audit,
+	}
+	signResponse(c, body)
+	c.JSON(http.StatusOK, body)
+}
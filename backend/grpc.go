@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// grpcCode is a gRPC status code
+type grpcCode int
+
+// The gRPC status codes the QChat service returns
+const (
+	codeOK                 grpcCode = 0
+	codeCanceled           grpcCode = 1
+	codeInvalidArgument    grpcCode = 3
+	codeDeadlineExceeded   grpcCode = 4
+	codeNotFound           grpcCode = 5
+	codeAlreadyExists      grpcCode = 6
+	codePermissionDenied   grpcCode = 7
+	codeResourceExhausted  grpcCode = 8
+	codeFailedPrecondition grpcCode = 9
+	codeAborted            grpcCode = 10
+	codeUnimplemented      grpcCode = 12
+	codeInternal           grpcCode = 13
+	codeUnavailable        grpcCode = 14
+	codeUnauthenticated    grpcCode = 16
+)
+
+// grpcMaxMessageBytes bounds a request message, matching grpc-go's default
+const grpcMaxMessageBytes = 4 << 20
+
+// grpcStatus is an error reported to a gRPC client as a status code and message
+type grpcStatus struct {
+	code    grpcCode
+	message string
+}
+
+func (s *grpcStatus) Error() string {
+	return fmt.Sprintf("gRPC status %d: %s", s.code, s.message)
+}
+
+func statusError(code grpcCode, format string, args ...any) error {
+	return &grpcStatus{code: code, message: fmt.Sprintf(format, args...)}
+}
+
+// statusOf returns the status reported for err. Errors a method did not
+// classify are internal, so their text is logged rather than returned.
+func statusOf(err error) *grpcStatus {
+	var status *grpcStatus
+	switch {
+	case errors.As(err, &status):
+		return status
+	case errors.Is(err, context.DeadlineExceeded):
+		return &grpcStatus{code: codeDeadlineExceeded, message: "deadline exceeded"}
+	case errors.Is(err, context.Canceled):
+		return &grpcStatus{code: codeCanceled, message: "canceled"}
+	}
+	log.Printf("grpc: %v", err)
+	return &grpcStatus{code: codeInternal, message: "internal error"}
+}
+
+// grpcMethod is one unary method. handle receives the request message in
+// its protobuf encoding, with the caller's authenticated identity, and
+// returns the encoded response.
+type grpcMethod struct {
+	authenticated bool // Requires a bearer token when tokens are configured, like its REST route
+	handle        func(ctx context.Context, identity string, req []byte) ([]byte, error)
+}
+
+// grpcServer serves unary gRPC methods over HTTP/2. It implements the gRPC
+// wire protocol directly, as length-prefixed protobuf messages with the
+// status in trailers, and supports neither streaming nor compression.
+type grpcServer struct {
+	tokens  map[string]string
+	methods map[string]grpcMethod // Keyed by path, such as "/qchat.v1.QChat/Encrypt"
+}
+
+func (s *grpcServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.ProtoMajor != 2 || !isGRPCContentType(r.Header.Get("Content-Type")) {
+		http.Error(w, "gRPC requests must be HTTP/2 POSTs of application/grpc", http.StatusUnsupportedMediaType)
+		return
+	}
+	w.Header().Set("Content-Type", "application/grpc")
+
+	method, ok := s.methods[r.URL.Path]
+	if !ok {
+		writeGRPCStatus(w, &grpcStatus{code: codeUnimplemented, message: "unknown method " + r.URL.Path})
+		return
+	}
+	if enc := r.Header.Get("Grpc-Encoding"); enc != "" && enc != "identity" {
+		w.Header().Set("Grpc-Accept-Encoding", "identity")
+		writeGRPCStatus(w, &grpcStatus{code: codeUnimplemented, message: "compression is not supported"})
+		return
+	}
+
+	identity := ""
+	if method.authenticated && len(s.tokens) > 0 {
+		if identity, ok = tokenIdentity(s.tokens, r.Header.Get("Authorization")); !ok {
+			writeGRPCStatus(w, &grpcStatus{code: codeUnauthenticated, message: "Invalid or missing token"})
+			return
+		}
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	if v := r.Header.Get("Grpc-Timeout"); v != "" {
+		timeout, err := parseGRPCTimeout(v)
+		if err != nil {
+			cancel()
+			writeGRPCStatus(w, &grpcStatus{code: codeInvalidArgument, message: err.Error()})
+			return
+		}
+		ctx, cancel = context.WithTimeout(r.Context(), timeout)
+	}
+	defer cancel()
+
+	req, err := readGRPCMessage(r.Body)
+	if err != nil {
+		writeGRPCStatus(w, statusOf(err))
+		return
+	}
+	resp, err := method.handle(ctx, identity, req)
+	if err != nil {
+		writeGRPCStatus(w, statusOf(err))
+		return
+	}
+
+	frame := make([]byte, 5, 5+len(resp))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(resp)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(append(frame, resp...))
+	w.(http.Flusher).Flush()
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(int(codeOK)))
+}
+
+// isGRPCContentType reports whether a content type is application/grpc or
+// one of its +proto variants
+func isGRPCContentType(ct string) bool {
+	return ct == "application/grpc" || ct == "application/grpc+proto" || strings.HasPrefix(ct, "application/grpc;")
+}
+
+// writeGRPCStatus ends a call without a response message. The status goes
+// in the headers, as the trailers-only response gRPC uses for errors.
+func writeGRPCStatus(w http.ResponseWriter, status *grpcStatus) {
+	w.Header().Set("Grpc-Status", strconv.Itoa(int(status.code)))
+	w.Header().Set("Grpc-Message", percentEncode(status.message))
+	w.WriteHeader(http.StatusOK)
+}
+
+// percentEncode escapes a grpc-message value: every byte outside printable
+// ASCII, and '%' itself
+func percentEncode(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c < 0x20 || c > 0x7e || c == '%' {
+			fmt.Fprintf(&sb, "%%%02X", c)
+		} else {
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
+
+// readGRPCMessage reads the single, uncompressed message of a unary call
+func readGRPCMessage(body io.Reader) ([]byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(body, header[:]); err != nil {
+		return nil, statusError(codeInvalidArgument, "missing request message: %v", err)
+	}
+	if header[0] != 0 {
+		return nil, statusError(codeUnimplemented, "compression is not supported")
+	}
+	n := binary.BigEndian.Uint32(header[1:])
+	if n > grpcMaxMessageBytes {
+		return nil, statusError(codeResourceExhausted, "request message is %d bytes, limit is %d", n, grpcMaxMessageBytes)
+	}
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(body, msg); err != nil {
+		return nil, statusError(codeInvalidArgument, "truncated request message: %v", err)
+	}
+	if extra, _ := body.Read(header[:1]); extra > 0 {
+		return nil, statusError(codeUnimplemented, "streaming requests are not supported")
+	}
+	return msg, nil
+}
+
+// parseGRPCTimeout parses a grpc-timeout header: up to eight digits and a
+// unit of H, M, S, m (milliseconds), u (microseconds) or n (nanoseconds)
+func parseGRPCTimeout(v string) (time.Duration, error) {
+	units := map[byte]time.Duration{
+		'H': time.Hour, 'M': time.Minute, 'S': time.Second,
+		'm': time.Millisecond, 'u': time.Microsecond, 'n': time.Nanosecond,
+	}
+	if len(v) < 2 || len(v) > 9 {
+		return 0, fmt.Errorf("invalid grpc-timeout %q", v)
+	}
+	unit, ok := units[v[len(v)-1]]
+	n, err := strconv.ParseUint(v[:len(v)-1], 10, 64)
+	if !ok || err != nil {
+		return 0, fmt.Errorf("invalid grpc-timeout %q", v)
+	}
+	return time.Duration(n) * unit, nil
+}
+
+// serveGRPC serves the QChat gRPC service on addr, apart from the REST API,
+// with TLS when certFile is set and as cleartext HTTP/2 otherwise. It
+// returns immediately; a listener failure is logged rather than fatal.
+func serveGRPC(addr string, tokens map[string]string, certFile, keyFile string) {
+	server := &http.Server{Addr: addr, Handler: newQChatGRPCServer(tokens)}
+	go func() {
+		log.Printf("serving gRPC on %s", addr)
+		var err error
+		if certFile != "" {
+			err = server.ListenAndServeTLS(certFile, keyFile)
+		} else {
+			server.Handler = h2c.NewHandler(server.Handler, &http2.Server{})
+			err = server.ListenAndServe()
+		}
+		if err != nil {
+			log.Printf("grpc: %v", err)
+		}
+	}()
+}
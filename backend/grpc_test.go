@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// grpcTestServer serves the QChat service over cleartext HTTP/2 and
+// returns a function that calls one of its methods
+func grpcTestServer(t *testing.T, tokens map[string]string) func(method, token string, req []byte) ([]byte, grpcCode, string) {
+	t.Helper()
+	server := httptest.NewServer(h2c.NewHandler(newQChatGRPCServer(tokens), &http2.Server{}))
+	t.Cleanup(server.Close)
+	client := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+	}}
+
+	return func(method, token string, req []byte) ([]byte, grpcCode, string) {
+		t.Helper()
+		body := make([]byte, 5, 5+len(req))
+		binary.BigEndian.PutUint32(body[1:], uint32(len(req)))
+		httpReq, err := http.NewRequest(http.MethodPost, server.URL+"/qchat.v1.QChat/"+method, bytes.NewReader(append(body, req...)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		httpReq.Header.Set("Content-Type", "application/grpc")
+		httpReq.Header.Set("TE", "trailers")
+		if token != "" {
+			httpReq.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := client.Do(httpReq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Errors arrive trailers-only, with the status in the headers
+		status := resp.Header
+		if status.Get("Grpc-Status") == "" {
+			status = resp.Trailer
+		}
+		code, err := strconv.Atoi(status.Get("Grpc-Status"))
+		if err != nil {
+			t.Fatalf("%s: no grpc-status in headers %v or trailers %v", method, resp.Header, resp.Trailer)
+		}
+		if code != int(codeOK) {
+			return nil, grpcCode(code), status.Get("Grpc-Message")
+		}
+		if len(data) < 5 || int(binary.BigEndian.Uint32(data[1:5])) != len(data)-5 {
+			t.Fatalf("%s: malformed response body %x", method, data)
+		}
+		return data[5:], codeOK, ""
+	}
+}
+
+// pbString and pbInt encode one field, independently of the server's codec
+func pbString(b []byte, num protowire.Number, s string) []byte {
+	return protowire.AppendString(protowire.AppendTag(b, num, protowire.BytesType), s)
+}
+
+func pbInt(b []byte, num protowire.Number, v uint64) []byte {
+	return protowire.AppendVarint(protowire.AppendTag(b, num, protowire.VarintType), v)
+}
+
+// pbFields decodes the length-delimited and varint fields of msg by number
+func pbFields(t *testing.T, msg []byte) map[protowire.Number][]protoField {
+	t.Helper()
+	fields := make(map[protowire.Number][]protoField)
+	if err := decodeProto(msg, func(f protoField) error {
+		fields[f.num] = append(fields[f.num], f)
+		return nil
+	}); err != nil {
+		t.Fatalf("decode %x: %v", msg, err)
+	}
+	return fields
+}
+
+// TestGRPCMessageFlow encrypts, lists and decrypts messages over gRPC on a
+// session shared with the REST API
+func TestGRPCMessageFlow(t *testing.T) {
+	call := grpcTestServer(t, map[string]string{"alice-token": "alice"})
+	r := rand.New(rand.NewSource(19))
+	sessions.Put(&Session{ID: "grpc-flow", Channel: randomChannel(t, r, 256)})
+
+	var req []byte
+	req = pbString(req, 1, "grpc-flow")
+	req = pbString(req, 2, "hello over gRPC")
+	req = pbString(req, 4, "general")
+	if _, code, _ := call("Encrypt", "", req); code != codeUnauthenticated {
+		t.Errorf("Encrypt without a token: code %d, want Unauthenticated", code)
+	}
+	if _, code, _ := call("Encrypt", "alice-token", pbString(req, 3, "mallory")); code != codePermissionDenied {
+		t.Errorf("Encrypt as another sender: code %d, want PermissionDenied", code)
+	}
+	encrypted, code, message := call("Encrypt", "alice-token", req)
+	if code != codeOK {
+		t.Fatalf("Encrypt: code %d, %s", code, message)
+	}
+	fields := pbFields(t, encrypted)
+	if len(fields[3]) != 1 || len(fields[5]) != 1 || string(fields[4][0].b) != "alice" {
+		t.Fatalf("Encrypt returned %x, want a message from alice with a ciphertext and room", encrypted)
+	}
+
+	// The REST API sees the same history
+	if _, err := mustGetSession(t, "grpc-flow").Channel.EncryptMessage("hello over REST", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	listed, code, message := call("ListMessages", "", pbString(nil, 1, "grpc-flow"))
+	if code != codeOK {
+		t.Fatalf("ListMessages: code %d, %s", code, message)
+	}
+	if n := len(pbFields(t, listed)[1]); n != 2 {
+		t.Fatalf("ListMessages returned %d messages, want 2", n)
+	}
+	inRoom, _, _ := call("ListMessages", "", pbString(pbString(nil, 1, "grpc-flow"), 2, "general"))
+	if n := len(pbFields(t, inRoom)[1]); n != 1 {
+		t.Errorf("ListMessages in room general returned %d messages, want 1", n)
+	}
+	defaultRoom, _, _ := call("ListMessages", "", pbString(pbString(nil, 1, "grpc-flow"), 2, ""))
+	if n := len(pbFields(t, defaultRoom)[1]); n != 1 {
+		t.Errorf("ListMessages in the default room returned %d messages, want 1", n)
+	}
+
+	decryptReq := protowire.AppendBytes(protowire.AppendTag(pbString(nil, 1, "grpc-flow"), 2, protowire.BytesType), encrypted)
+	decrypted, code, message := call("Decrypt", "", decryptReq)
+	if code != codeOK {
+		t.Fatalf("Decrypt: code %d, %s", code, message)
+	}
+	if got := pbFields(t, decrypted)[1]; len(got) != 1 || string(got[0].b) != "hello over gRPC" {
+		t.Errorf("Decrypt returned %x", decrypted)
+	}
+
+	// A tampered ciphertext fails authentication
+	msg, err := unmarshalMessage(encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(msg.Ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext[0] ^= 1
+	msg.Ciphertext = base64.StdEncoding.EncodeToString(ciphertext)
+	tampered := protowire.AppendBytes(protowire.AppendTag(pbString(nil, 1, "grpc-flow"), 2, protowire.BytesType), marshalMessage(nil, msg))
+	if _, code, _ := call("Decrypt", "", tampered); code != codeInvalidArgument {
+		t.Errorf("Decrypt of a tampered message: code %d, want InvalidArgument", code)
+	}
+}
+
+// mustGetSession returns a stored session, failing the test if it is missing
+func mustGetSession(t *testing.T, id string) *Session {
+	t.Helper()
+	session, err := sessions.Get(id)
+	if err != nil {
+		t.Fatalf("session %q: %v", id, err)
+	}
+	return session
+}
+
+// TestGRPCInitialize runs a protocol over gRPC and checks that bad
+// parameters and unknown methods are rejected with the matching codes
+func TestGRPCInitialize(t *testing.T) {
+	call := grpcTestServer(t, nil)
+
+	resp, code, message := call("Initialize", "", pbInt(pbString(nil, 1, "grpc-init"), 2, 2048))
+	if code != codeOK {
+		t.Fatalf("Initialize: code %d, %s", code, message)
+	}
+	fields := pbFields(t, resp)
+	if len(fields[1]) != 1 || string(fields[1][0].b) != "grpc-init" || string(fields[2][0].b) != string(ProtocolBB84) {
+		t.Errorf("Initialize returned %x", resp)
+	}
+	if len(fields[4]) != 1 || fields[4][0].n == 0 {
+		t.Errorf("Initialize reported no sifted bits: %x", resp)
+	}
+	mustGetSession(t, "grpc-init")
+
+	if _, code, _ := call("Initialize", "", pbString(nil, 3, "e91")); code != codeInvalidArgument {
+		t.Errorf("unknown protocol: code %d, want InvalidArgument", code)
+	}
+	if _, code, _ := call("Initialize", "", pbInt(nil, 2, uint64(maxBits+1))); code != codeInvalidArgument {
+		t.Errorf("too many bits: code %d, want InvalidArgument", code)
+	}
+	if _, code, _ := call("Initialize", "", []byte{0x0a, 0x05, 'x'}); code != codeInvalidArgument {
+		t.Errorf("truncated request: code %d, want InvalidArgument", code)
+	}
+	if _, code, _ := call("ListMessages", "", pbString(nil, 1, "grpc-missing")); code != codeNotFound {
+		t.Errorf("unknown session: code %d, want NotFound", code)
+	}
+	if _, code, _ := call("Subscribe", "", nil); code != codeUnimplemented {
+		t.Errorf("unknown method: code %d, want Unimplemented", code)
+	}
+}
+
+// TestGRPCMessageCodec round-trips a message with every field set
+func TestGRPCMessageCodec(t *testing.T) {
+	want := Message{
+		Seq: 7, Kind: KindEncrypted, Ciphertext: "Y2lwaGVy", Sender: "alice", Room: "general",
+		Offset: 1024, MAC: "bWFj", MacAlgorithm: "hmac-sha256", Compressed: true, Cascade: true,
+		Watermark: true, Type: "typing", Supersedes: 3, SupersededBy: 9,
+		Timestamp: time.Date(2024, 5, 6, 7, 8, 9, 123456789, time.UTC),
+	}
+	got, err := unmarshalMessage(marshalMessage(nil, &want))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Timestamp.Equal(want.Timestamp) {
+		t.Errorf("timestamp %v, want %v", got.Timestamp, want.Timestamp)
+	}
+	got.Timestamp = want.Timestamp
+	if *got != want {
+		t.Errorf("round trip gave %+v, want %+v", *got, want)
+	}
+}
+
+func TestParseGRPCTimeout(t *testing.T) {
+	for v, want := range map[string]time.Duration{
+		"1H": time.Hour, "30S": 30 * time.Second, "250m": 250 * time.Millisecond,
+		"99999999n": 99999999 * time.Nanosecond, "5u": 5 * time.Microsecond,
+	} {
+		if got, err := parseGRPCTimeout(v); err != nil || got != want {
+			t.Errorf("parseGRPCTimeout(%q) = %v, %v; want %v", v, got, err, want)
+		}
+	}
+	for _, v := range []string{"", "S", "10", "10s", "-1S", "123456789S"} {
+		if _, err := parseGRPCTimeout(v); err == nil {
+			t.Errorf("parseGRPCTimeout(%q) succeeded", v)
+		}
+	}
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// The QChat gRPC service of proto/qchat.proto. Its messages are encoded and
+// decoded here with protowire, field by field, so the field numbers below
+// must match the .proto file.
+
+// newQChatGRPCServer returns the QChat service, with Encrypt requiring one
+// of tokens when any are configured
+func newQChatGRPCServer(tokens map[string]string) *grpcServer {
+	return &grpcServer{
+		tokens: tokens,
+		methods: map[string]grpcMethod{
+			"/qchat.v1.QChat/Initialize":   {handle: grpcInitialize},
+			"/qchat.v1.QChat/Encrypt":      {handle: grpcEncrypt, authenticated: true},
+			"/qchat.v1.QChat/Decrypt":      {handle: grpcDecrypt},
+			"/qchat.v1.QChat/ListMessages": {handle: grpcListMessages},
+		},
+	}
+}
+
+// protoField is one field of an encoded message
+type protoField struct {
+	num protowire.Number
+	typ protowire.Type
+	n   uint64 // Value of a varint field
+	b   []byte // Value of a length-delimited field
+}
+
+// is reports whether the field has the given number and wire type. Like the
+// protobuf runtime, decoders treat a field of the wrong type as unknown.
+func (f protoField) is(num protowire.Number, typ protowire.Type) bool {
+	return f.num == num && f.typ == typ
+}
+
+// decodeProto calls field for each field of msg, in order
+func decodeProto(msg []byte, field func(protoField) error) error {
+	for len(msg) > 0 {
+		num, typ, n := protowire.ConsumeTag(msg)
+		if n < 0 {
+			return statusError(codeInvalidArgument, "malformed request: %v", protowire.ParseError(n))
+		}
+		msg = msg[n:]
+
+		f := protoField{num: num, typ: typ}
+		switch typ {
+		case protowire.VarintType:
+			f.n, n = protowire.ConsumeVarint(msg)
+		case protowire.BytesType:
+			f.b, n = protowire.ConsumeBytes(msg)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, msg)
+		}
+		if n < 0 {
+			return statusError(codeInvalidArgument, "malformed request: %v", protowire.ParseError(n))
+		}
+		msg = msg[n:]
+		if err := field(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The append helpers omit fields holding their zero value, as proto3 does
+
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	return protowire.AppendString(protowire.AppendTag(b, num, protowire.BytesType), s)
+}
+
+func appendInt(b []byte, num protowire.Number, v int) []byte {
+	if v == 0 {
+		return b
+	}
+	return protowire.AppendVarint(protowire.AppendTag(b, num, protowire.VarintType), uint64(v))
+}
+
+func appendBool(b []byte, num protowire.Number, v bool) []byte {
+	if !v {
+		return b
+	}
+	return protowire.AppendVarint(protowire.AppendTag(b, num, protowire.VarintType), 1)
+}
+
+func appendDouble(b []byte, num protowire.Number, v float64) []byte {
+	if v == 0 {
+		return b
+	}
+	return protowire.AppendFixed64(protowire.AppendTag(b, num, protowire.Fixed64Type), math.Float64bits(v))
+}
+
+// marshalMessage encodes a stored message as a qchat.v1.Message
+func marshalMessage(b []byte, msg *Message) []byte {
+	b = appendInt(b, 1, msg.Seq)
+	b = appendString(b, 2, string(msg.Kind))
+	b = appendString(b, 3, msg.Ciphertext)
+	b = appendString(b, 4, msg.Sender)
+	b = appendString(b, 5, msg.Room)
+	b = appendInt(b, 6, msg.Offset)
+	b = appendString(b, 7, msg.MAC)
+	b = appendString(b, 8, string(msg.MacAlgorithm))
+	b = appendBool(b, 9, msg.Compressed)
+	b = appendBool(b, 10, msg.Cascade)
+	b = appendBool(b, 11, msg.Watermark)
+	b = appendString(b, 12, msg.Type)
+	b = appendInt(b, 13, msg.Supersedes)
+	b = appendInt(b, 14, msg.SupersededBy)
+	if !msg.Timestamp.IsZero() {
+		b = appendString(b, 15, msg.Timestamp.Format(time.RFC3339Nano))
+	}
+	return b
+}
+
+// unmarshalMessage decodes a qchat.v1.Message
+func unmarshalMessage(data []byte) (*Message, error) {
+	msg := &Message{}
+	err := decodeProto(data, func(f protoField) error {
+		switch {
+		case f.is(1, protowire.VarintType):
+			msg.Seq = int(int64(f.n))
+		case f.is(2, protowire.BytesType):
+			msg.Kind = MessageKind(f.b)
+		case f.is(3, protowire.BytesType):
+			msg.Ciphertext = string(f.b)
+		case f.is(4, protowire.BytesType):
+			msg.Sender = string(f.b)
+		case f.is(5, protowire.BytesType):
+			msg.Room = string(f.b)
+		case f.is(6, protowire.VarintType):
+			msg.Offset = int(int64(f.n))
+		case f.is(7, protowire.BytesType):
+			msg.MAC = string(f.b)
+		case f.is(8, protowire.BytesType):
+			msg.MacAlgorithm = MacAlgorithm(f.b)
+		case f.is(9, protowire.VarintType):
+			msg.Compressed = f.n != 0
+		case f.is(10, protowire.VarintType):
+			msg.Cascade = f.n != 0
+		case f.is(11, protowire.VarintType):
+			msg.Watermark = f.n != 0
+		case f.is(12, protowire.BytesType):
+			msg.Type = string(f.b)
+		case f.is(13, protowire.VarintType):
+			msg.Supersedes = int(int64(f.n))
+		case f.is(14, protowire.VarintType):
+			msg.SupersededBy = int(int64(f.n))
+		case f.is(15, protowire.BytesType):
+			t, err := time.Parse(time.RFC3339Nano, string(f.b))
+			if err != nil {
+				return statusError(codeInvalidArgument, "timestamp must be RFC 3339")
+			}
+			msg.Timestamp = t
+		}
+		return nil
+	})
+	return msg, err
+}
+
+// grpcSession looks up a request's session, the default one when id is empty
+func grpcSession(id string) (*Session, error) {
+	if id == "" {
+		id = defaultSessionID
+	}
+	session, err := sessions.Get(id)
+	if errors.Is(err, ErrSessionEvicted) {
+		return nil, statusError(codeNotFound, "Session was evicted; initialize it again")
+	}
+	if err != nil {
+		return nil, statusError(codeNotFound, "Secure channel not initialized")
+	}
+	return session, nil
+}
+
+// Initialize runs a protocol with the server's default protocol and channel
+// options, as POST /initialize does without an encryptionConfig
+func grpcInitialize(ctx context.Context, _ string, data []byte) ([]byte, error) {
+	var sessionID, protocol string
+	bits := 0
+	err := decodeProto(data, func(f protoField) error {
+		switch {
+		case f.is(1, protowire.BytesType):
+			sessionID = string(f.b)
+		case f.is(2, protowire.VarintType):
+			bits = int(int32(f.n))
+		case f.is(3, protowire.BytesType):
+			protocol = string(f.b)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if sessionID == "" {
+		sessionID = defaultSessionID
+	}
+	if err := checkBits(bits); err != nil {
+		return nil, statusError(codeInvalidArgument, "%v", err)
+	}
+	if err := checkProtocol(ProtocolName(protocol)); err != nil {
+		return nil, statusError(codeInvalidArgument, "%v", err)
+	}
+
+	ctx, cancel := boundedContext(ctx)
+	defer cancel()
+	session, result, err := InitializeProtocol(ctx, sessionID, ProtocolName(protocol), bits, protocolOptions, channelOptions, nil)
+	switch {
+	case errors.Is(err, ErrSessionExists):
+		return nil, statusError(codeAlreadyExists, "%v", err)
+	case errors.Is(err, ErrKeyNotExtendable), errors.Is(err, ErrSessionQuarantined):
+		return nil, statusError(codeFailedPrecondition, "%v", err)
+	case err != nil:
+		return nil, fmt.Errorf("initialize session %q: %w", sessionID, err)
+	}
+	if result.Aborted {
+		code := codeAborted
+		if result.interrupted() {
+			code = codeUnavailable
+		}
+		return nil, statusError(code, "Protocol aborted: %s (QBER %.4f)", result.Reason, result.Qber)
+	}
+
+	var b []byte
+	b = appendString(b, 1, session.ID)
+	b = appendString(b, 2, string(session.Protocol.Name()))
+	b = appendDouble(b, 3, result.Qber)
+	b = appendInt(b, 4, result.SiftedLength)
+	b = appendInt(b, 5, result.Distillation.SecureBits)
+	return b, nil
+}
+
+// Encrypt stores a message as POST /encrypt does, returning it in full
+func grpcEncrypt(_ context.Context, identity string, data []byte) ([]byte, error) {
+	var sessionID, plaintext, claimed, room string
+	err := decodeProto(data, func(f protoField) error {
+		switch {
+		case f.is(1, protowire.BytesType):
+			sessionID = string(f.b)
+		case f.is(2, protowire.BytesType):
+			plaintext = string(f.b)
+		case f.is(3, protowire.BytesType):
+			claimed = string(f.b)
+		case f.is(4, protowire.BytesType):
+			room = string(f.b)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	session, err := grpcSession(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	sender, err := senderFor(claimed, identity)
+	if errors.Is(err, ErrSenderMismatch) {
+		return nil, statusError(codePermissionDenied, "Sender does not match authenticated identity")
+	}
+	if err != nil {
+		return nil, statusError(codeInvalidArgument, "%v", err)
+	}
+	if room, err = normalizeRoom(room); err != nil {
+		return nil, statusError(codeInvalidArgument, "%v", err)
+	}
+
+	msg, err := session.Channel.EncryptInRoom(plaintext, sender, room)
+	switch {
+	case errors.Is(err, ErrSessionQuarantined):
+		return nil, statusError(codeFailedPrecondition, "Session is quarantined")
+	case errors.Is(err, ErrQBERNotChecked):
+		return nil, statusError(codeFailedPrecondition, "Key was never checked for eavesdropping")
+	case errors.Is(err, ErrEmptyPlaintext):
+		return nil, statusError(codeInvalidArgument, "Plaintext must not be empty")
+	case errors.Is(err, ErrMessageTooLong), errors.Is(err, ErrKeyExhausted), errors.Is(err, ErrNoKeyPartition):
+		return nil, statusError(codeResourceExhausted, "%v", err)
+	case err != nil:
+		return nil, statusError(codeInternal, "Failed to encrypt message")
+	}
+	return marshalMessage(nil, msg), nil
+}
+
+// Decrypt authenticates and decrypts a message as POST /decrypt does
+func grpcDecrypt(_ context.Context, _ string, data []byte) ([]byte, error) {
+	var sessionID string
+	var msg *Message
+	err := decodeProto(data, func(f protoField) error {
+		var err error
+		switch {
+		case f.is(1, protowire.BytesType):
+			sessionID = string(f.b)
+		case f.is(2, protowire.BytesType):
+			msg, err = unmarshalMessage(f.b)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if msg == nil {
+		return nil, statusError(codeInvalidArgument, "message is required")
+	}
+	session, err := grpcSession(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := session.Channel.DecryptMessage(msg)
+	if errors.Is(err, ErrSessionQuarantined) {
+		return nil, statusError(codeFailedPrecondition, "Session is quarantined")
+	}
+	if err != nil {
+		decryptMonitor.RecordFailure(session.ID, msg.Sender, err)
+		return nil, statusError(codeInvalidArgument, "Failed to decrypt message")
+	}
+	if plaintext == "" {
+		return nil, nil
+	}
+	return protowire.AppendBytes(protowire.AppendTag(nil, 1, protowire.BytesType), []byte(plaintext)), nil
+}
+
+// ListMessages returns a session's history, or one room's when room is set,
+// as GET /messages does without pagination
+func grpcListMessages(_ context.Context, _ string, data []byte) ([]byte, error) {
+	var sessionID, room string
+	inRoom := false
+	err := decodeProto(data, func(f protoField) error {
+		switch {
+		case f.is(1, protowire.BytesType):
+			sessionID = string(f.b)
+		case f.is(2, protowire.BytesType):
+			room, inRoom = string(f.b), true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	session, err := grpcSession(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	var history []Message
+	if inRoom {
+		if room, err = normalizeRoom(room); err != nil {
+			return nil, statusError(codeInvalidArgument, "%v", err)
+		}
+		history = session.Channel.RoomHistory(room)
+	} else {
+		history = session.Channel.History()
+	}
+	var b, entry []byte
+	for i := range history {
+		entry = marshalMessage(entry[:0], &history[i])
+		b = protowire.AppendBytes(protowire.AppendTag(b, 1, protowire.BytesType), entry)
+	}
+	return b, nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// InjectErrorRequest asks for a protocol run with simulated eavesdropping
+type InjectErrorRequest struct {
+	Bits     int     `json:"bits"`
+	Fraction float64 `json:"fraction"`
+}
+
+// injectErrors flips Bob's measurement on roughly InjectedErrorRate of the
+// sifted positions, so the flips surface in the QBER estimate
+func (bb84 *BB84Protocol) injectErrors() error {
+	if bb84.InjectedErrorRate <= 0 {
+		return nil
+	}
+	for i, idx := range bb84.siftedIndices {
+		flip, err := bernoulli(bb84.Rand, bb84.InjectedErrorRate)
+		if err != nil {
+			return err
+		}
+		if flip {
+			bb84.Bob.measuredBits[idx] ^= 1
+			bb84.bobSiftedKey[i] ^= 1
+		}
+	}
+	return nil
+}
+
+// Re-key a session with a tunable fraction of sifted bits flipped on Bob's
+// side. This is a classroom simulation of eavesdropping strength: it lets an
+// instructor watch QBER-based detection succeed or fail.
+func injectErrorHandler(c *gin.Context) {
+	var req InjectErrorRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Fraction < 0 || req.Fraction > 1 {
+		respondError(c, http.StatusBadRequest, "Invalid request format")
+		return
+	}
+	if !validBits(c, req.Bits) {
+		return
+	}
+
+	opts := protocolOptions
+	opts.InjectedErrorRate = req.Fraction
+	runProtocol(c, ProtocolBB84, req.Bits, opts, channelOptions)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"crypto/sha256"
+	"io"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/hkdf"
+)
+
+// KDF selects how subkeys such as the MAC key are derived from a shared key
+type KDF int
+
+const (
+	KDFHKDF   KDF = iota // HKDF-SHA256; for full-entropy keys from a protocol run
+	KDFArgon2            // Argon2id; slows guessing of low-entropy preshared keys
+)
+
+// subkeyLength is the size of every derived subkey in bytes
+const subkeyLength = 32
+
+// Argon2id cost parameters, following the second recommended option of RFC 9106
+const (
+	argon2Time    = 3
+	argon2Memory  = 64 * 1024 // KiB
+	argon2Threads = 4
+)
+
+func (k KDF) String() string {
+	if k == KDFArgon2 {
+		return "argon2id"
+	}
+	return "hkdf"
+}
+
+// deriveSubkey derives a purpose-specific key from the shared key bytes. The
+// label domain-separates subkeys and doubles as the Argon2id salt, since both
+// parties must derive the same key without exchanging anything.
+func deriveSubkey(kdf KDF, keyBytes []byte, label string) []byte {
+	if kdf == KDFArgon2 {
+		return argon2.IDKey(keyBytes, []byte(label), argon2Time, argon2Memory, argon2Threads, subkeyLength)
+	}
+
+	subkey := make([]byte, subkeyLength)
+	r := hkdf.New(sha256.New, keyBytes, nil, []byte(label))
+	if _, err := io.ReadFull(r, subkey); err != nil {
+		panic(err) // HKDF-SHA256 can produce far more than subkeyLength bytes
+	}
+	return subkey
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDeriveSubkey(t *testing.T) {
+	keyBytes := []byte("a short preshared key")
+	for _, kdf := range []KDF{KDFHKDF, KDFArgon2} {
+		mac := deriveSubkey(kdf, keyBytes, macKeyLabel)
+		if len(mac) != subkeyLength {
+			t.Errorf("%v: subkey has %d bytes, want %d", kdf, len(mac), subkeyLength)
+		}
+		if !bytes.Equal(mac, deriveSubkey(kdf, keyBytes, macKeyLabel)) {
+			t.Errorf("%v: derivation is not deterministic", kdf)
+		}
+		if bytes.Equal(mac, deriveSubkey(kdf, keyBytes, cascadeKeyLabel)) {
+			t.Errorf("%v: labels do not separate subkeys", kdf)
+		}
+	}
+	if bytes.Equal(deriveSubkey(KDFHKDF, keyBytes, macKeyLabel), deriveSubkey(KDFArgon2, keyBytes, macKeyLabel)) {
+		t.Error("HKDF and Argon2id derived the same subkey")
+	}
+}
+
+// BenchmarkDeriveSubkey compares the cost of one subkey under each KDF,
+// which is what an attacker guessing a preshared key pays per guess
+func BenchmarkDeriveSubkey(b *testing.B) {
+	keyBytes := []byte("a short preshared key")
+	for _, kdf := range []KDF{KDFHKDF, KDFArgon2} {
+		b.Run(kdf.String(), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				deriveSubkey(kdf, keyBytes, macKeyLabel)
+			}
+		})
+	}
+}
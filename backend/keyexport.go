@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// pemKeyType is the PEM block type used for exported shared keys
+const pemKeyType = "QKD SHARED KEY"
+
+// ImportKeyRequest carries a preshared key as base64 bytes or a PEM block
+type ImportKeyRequest struct {
+	Key string `json:"key"`
+	PEM string `json:"pem"`
+}
+
+// EncodeKeyPEM wraps the packed key bytes in a PEM block. The exact bit
+// length is recorded in a header since packing pads to whole bytes.
+func EncodeKeyPEM(sessionID string, key []int) []byte {
+	return pem.EncodeToMemory(&pem.Block{
+		Type: pemKeyType,
+		Headers: map[string]string{
+			"Session-Id": sessionID,
+			"Key-Bits":   strconv.Itoa(len(key)),
+		},
+		Bytes: convertKeyToBytes(key),
+	})
+}
+
+// DecodeKeyPEM parses a PEM block produced by EncodeKeyPEM back into key bits
+func DecodeKeyPEM(data []byte) ([]int, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != pemKeyType {
+		return nil, errors.New("no " + pemKeyType + " PEM block found")
+	}
+	key := bytesToKey(block.Bytes)
+	if v, ok := block.Headers["Key-Bits"]; ok {
+		bits, err := strconv.Atoi(v)
+		if err != nil || bits < 0 || bits > len(key) {
+			return nil, errors.New("invalid Key-Bits header")
+		}
+		key = key[:bits]
+	}
+	return key, nil
+}
+
+// keyPEM encodes the channel's shared key as PEM. It holds sc.mu because
+// appending to the key or wiping the channel rewrites SharedKey.
+func (sc *SecureChannel) keyPEM(sessionID string) []byte {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+	return EncodeKeyPEM(sessionID, sc.SharedKey)
+}
+
+// Export a session's shared key as PEM
+func keyPEMHandler(c *gin.Context) {
+	session, ok := sessionFromRequest(c)
+	if !ok {
+		return
+	}
+
+	c.Header("Content-Disposition", `attachment; filename="key.pem"`)
+	c.Data(http.StatusOK, "application/x-pem-file", session.Channel.keyPEM(session.ID))
+}
+
+// Import a preshared key, given as base64 bytes or PEM, into a session
+func importKeyHandler(c *gin.Context) {
+	var req ImportKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil || (req.Key == "") == (req.PEM == "") {
+		respondError(c, http.StatusBadRequest, "Provide exactly one of key or pem")
+		return
+	}
+
+	var key []int
+	if req.PEM != "" {
+		var err error
+		if key, err = DecodeKeyPEM([]byte(req.PEM)); err != nil {
+			respondError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+	} else {
+		raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(req.Key))
+		if err != nil {
+			respondError(c, http.StatusBadRequest, "key must be base64")
+			return
+		}
+		key = bytesToKey(raw)
+	}
+	if len(key) == 0 {
+		respondError(c, http.StatusBadRequest, "key is empty")
+		return
+	}
+	if entropy := byteEntropy(convertKeyToBytes(key)); entropy < protocolOptions.MinKeyEntropy {
+		respondError(c, http.StatusUnprocessableEntity, fmt.Sprintf("key entropy %.2f bits per byte is below the minimum %.2f", entropy, protocolOptions.MinKeyEntropy))
+		return
+	}
+
+	session, err := ImportKey(c.DefaultQuery("sessionId", defaultSessionID), key)
+	if errors.Is(err, ErrDegenerateKey) {
+		respondError(c, http.StatusUnprocessableEntity, "key bits are all identical")
+		return
+	}
+	if errors.Is(err, ErrSessionExists) || errors.Is(err, ErrKeyNotExtendable) || errors.Is(err, ErrSessionQuarantined) {
+		respondError(c, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Failed to import key")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Key imported successfully", "sessionId": session.ID, "keyBits": len(key)})
+}
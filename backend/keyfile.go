@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TakeKeyBytes claims the next n key bytes and returns a copy of them for
+// use outside the channel, such as encrypting files offline. The offset
+// advances past them, so they are never used for a message.
+func (sc *SecureChannel) TakeKeyBytes(n int) ([]byte, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("%w: byte count must be positive, got %d", ErrKeyRangeOutOfBounds, n)
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if sc.quarantined {
+		return nil, ErrSessionQuarantined
+	}
+	if sc.RequireQBERCheck && !sc.checked {
+		return nil, ErrQBERNotChecked
+	}
+	if sc.PartitionKeyBySender {
+		return nil, ErrKeyPartitioned
+	}
+	if sc.offset+n > len(sc.keyBytes) {
+		return nil, fmt.Errorf("%w: %d bytes requested, %d remaining", ErrKeyExhausted, n, len(sc.keyBytes)-sc.offset)
+	}
+	key, err := sc.keyStream(sc.offset, n)
+	if err != nil {
+		return nil, err
+	}
+	offset := sc.offset
+	sc.offset += n
+
+	if err := sc.Audit.Record(AuditEvent{Type: AuditKeyExported, Bytes: n, Offset: offset}); err != nil {
+		log.Printf("audit: %v", err)
+	}
+	return key, nil
+}
+
+// Download key bytes from a session as a one-time pad file
+func keyfileHandler(c *gin.Context) {
+	n, err := strconv.Atoi(c.Query("bytes"))
+	if err != nil || n <= 0 {
+		respondError(c, http.StatusBadRequest, "bytes must be a positive integer")
+		return
+	}
+
+	session, ok := sessionFromRequest(c)
+	if !ok {
+		return
+	}
+
+	key, err := session.Channel.TakeKeyBytes(n)
+	switch {
+	case errors.Is(err, ErrSessionQuarantined):
+		respondError(c, http.StatusLocked, "Session is quarantined")
+	case errors.Is(err, ErrQBERNotChecked):
+		respondError(c, http.StatusConflict, "Key was never checked for eavesdropping")
+	case errors.Is(err, ErrKeyPartitioned):
+		respondError(c, http.StatusConflict, "Key is partitioned by sender")
+	case errors.Is(err, ErrKeyExhausted):
+		respondError(c, http.StatusConflict, err.Error())
+	case err != nil:
+		respondError(c, http.StatusInternalServerError, "Failed to read key bytes")
+	default:
+		c.Header("Content-Disposition", `attachment; filename="key.bin"`)
+		c.Data(http.StatusOK, "application/octet-stream", key)
+	}
+}
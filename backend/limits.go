@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	// bytesPerRequestedBit approximates the memory one raw bit costs across
+	// Alice's bits and bases, Bob's bases, the channel and the sifted key
+	bytesPerRequestedBit = 64
+
+	// fallbackMaxBits is used when available memory cannot be determined
+	fallbackMaxBits = 1 << 22
+)
+
+// availableMemory returns the MemAvailable figure from /proc/meminfo in bytes
+func availableMemory() (uint64, bool) {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, false
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "MemAvailable:" {
+			kb, err := strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				return 0, false
+			}
+			return kb * 1024, true
+		}
+	}
+	return 0, false
+}
+
+// computeMaxBits returns the largest bit count a single request may ask for,
+// allowing one protocol run to use at most a quarter of available memory
+func computeMaxBits() int {
+	mem, ok := availableMemory()
+	if !ok {
+		return fallbackMaxBits
+	}
+	return int(mem / 4 / bytesPerRequestedBit)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+
+	"golang.org/x/crypto/poly1305"
+)
+
+// macKeyLabel domain-separates the MAC key from the encryption keystream
+const macKeyLabel = "qchat message authentication"
+
+// poly1305KeyLabel domain-separates the per-message Poly1305 keys
+const poly1305KeyLabel = "qchat poly1305 one-time key"
+
+// Errors returned by message authentication
+var (
+	ErrMACMismatch         = errors.New("message authentication failed")
+	ErrUnknownMacAlgorithm = errors.New("unknown MAC algorithm")
+)
+
+// MacAlgorithm names the algorithm used to authenticate a message
+type MacAlgorithm string
+
+const (
+	MacHMACSHA256 MacAlgorithm = "hmac-sha256" // Default; used when no algorithm is recorded
+	MacHMACSHA512 MacAlgorithm = "hmac-sha512" // Larger tag and margin, slower on small messages
+	MacPoly1305   MacAlgorithm = "poly1305"    // Fastest; keyed per message from the key offset
+)
+
+// Authenticator computes a message tag over data bound to a key offset
+type Authenticator interface {
+	Sum(key []byte, offset int, data []byte) []byte
+}
+
+// hmacAuthenticator authenticates with HMAC over the given hash
+type hmacAuthenticator struct {
+	hash func() hash.Hash
+}
+
+func (a hmacAuthenticator) Sum(key []byte, offset int, data []byte) []byte {
+	h := hmac.New(a.hash, key)
+	h.Write(offsetBytes(offset))
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// poly1305Authenticator authenticates with Poly1305. A Poly1305 key must
+// never authenticate two messages, so each tag uses a one-time key derived
+// from the MAC key and the message offset.
+type poly1305Authenticator struct{}
+
+func (poly1305Authenticator) Sum(key []byte, offset int, data []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(poly1305KeyLabel))
+	h.Write(offsetBytes(offset))
+
+	var oneTimeKey [32]byte
+	copy(oneTimeKey[:], h.Sum(nil))
+
+	var tag [poly1305.TagSize]byte
+	poly1305.Sum(&tag, data, &oneTimeKey)
+	return tag[:]
+}
+
+// newAuthenticator returns the Authenticator for algorithm, treating the
+// empty string as HMAC-SHA256 so messages from before the option still verify
+func newAuthenticator(algorithm MacAlgorithm) (Authenticator, error) {
+	switch algorithm {
+	case "", MacHMACSHA256:
+		return hmacAuthenticator{hash: sha256.New}, nil
+	case MacHMACSHA512:
+		return hmacAuthenticator{hash: sha512.New}, nil
+	case MacPoly1305:
+		return poly1305Authenticator{}, nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownMacAlgorithm, algorithm)
+	}
+}
+
+// macAlgorithm returns the channel's configured algorithm, defaulting to HMAC-SHA256
+func (sc *SecureChannel) macAlgorithm() MacAlgorithm {
+	if sc.MacAlgorithm == "" {
+		return MacHMACSHA256
+	}
+	return sc.MacAlgorithm
+}
+
+func offsetBytes(offset int) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(offset))
+	return b[:]
+}
+
+// compressedMarker prefixes the MAC input of compressed messages, so the flag
+// cannot be toggled without detection. It never starts a UTF-8 sender name.
+const compressedMarker = 0xff
+
+// messageFlags are the per-message processing flags and labels covered by
+// the MAC besides the sender and offset
+type messageFlags struct {
+	compressed bool
+	cascade    bool
+	watermark  bool
+	room       string
+}
+
+// computeMAC authenticates a ciphertext together with its offset, sender and
+// processing flags
+func (sc *SecureChannel) computeMAC(algorithm MacAlgorithm, offset int, sender string, flags messageFlags, cipherBytes []byte) (string, error) {
+	auth, err := newAuthenticator(algorithm)
+	if err != nil {
+		return "", err
+	}
+
+	data := make([]byte, 0, 6+len(flags.room)+len(sender)+len(cipherBytes))
+	if flags.compressed {
+		data = append(data, compressedMarker)
+	}
+	if flags.cascade {
+		data = append(data, cascadeMarker)
+	}
+	if flags.watermark {
+		data = append(data, watermarkMarker)
+	}
+	if flags.room != "" {
+		data = append(data, roomMarker)
+		data = append(data, flags.room...)
+		data = append(data, 0)
+	}
+	data = append(data, sender...)
+	data = append(data, 0)
+	data = append(data, cipherBytes...)
+	return sc.encoding().EncodeToString(auth.Sum(sc.macKey, offset, data)), nil
+}
+
+// verifyMAC checks a message MAC using the algorithm recorded on the
+// message, ignoring base64 padding. The comparison is constant-time, so a
+// forged tag gives no hint of how many leading bytes were right.
+func (sc *SecureChannel) verifyMAC(msg *Message, cipherBytes []byte) error {
+	expected, err := sc.computeMAC(msg.MacAlgorithm, msg.Offset, msg.Sender, messageFlags{msg.Compressed, msg.Cascade, msg.Watermark, msg.Room}, cipherBytes)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal([]byte(strings.TrimRight(expected, "=")), []byte(strings.TrimRight(msg.MAC, "="))) {
+		return ErrMACMismatch
+	}
+	return nil
+}
+
+// VerifyMessage checks a message's MAC without decrypting it
+func (sc *SecureChannel) VerifyMessage(msg *Message) error {
+	cipherBytes, err := sc.decodeCiphertext(msg)
+	if err != nil {
+		return err
+	}
+
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+	if sc.quarantined {
+		return ErrSessionQuarantined
+	}
+	return sc.verifyMAC(msg, cipherBytes)
+}
@@ -1,89 +1,439 @@
 package main
 
 import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"flag"
+	"fmt"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"io"
+	"log"
+	"math"
 	"net/http"
+	"strconv"
 	"sync"
+	"time"
 )
 
 // Global variables
 var (
-	mutex          sync.Mutex
-	activeProtocol *BB84Protocol
-	secureChannel  *SecureChannel
+	mutex           sync.Mutex
+	sessions                    = NewSessionManager()
+	auditLogger     AuditLogger = nopAuditLogger{}
+	mode                        = ModeDevelopment
+	channelOptions  ChannelOptions
+	protocolOptions = DefaultProtocolOptions()
+	maxBits         = fallbackMaxBits
+	protocolTimeout = defaultProtocolTimeout
+	decryptMonitor  = NewDecryptMonitor(0, time.Minute, "")
+	entropySelfTest EntropySelfTest
+	randRetries     = DefaultRandRetries
+	adminToken      string
+	signingKey      ed25519.PrivateKey
+	jsonNamingStyle JSONNaming
+	presharedKDF    = KDFArgon2
+	maxSenderLength = DefaultMaxSenderLength
+
+	// Entropy for key generation: crypto/rand unless QCHAT_RAND_SOURCE names a device
+	entropySource RandSource = cryptoRandPool
+	entropyReader io.Reader  = rand.Reader
+)
+
+// Pagination limits for /messages
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
 )
 
 // Request and Response Models
 type InitRequest struct {
-	Bits int `json:"bits"`
+	Bits     int          `json:"bits"`
+	Protocol ProtocolName `json:"protocol"` // bb84 (default) or sarg04
+
+	// TargetSiftedBits, when set, sends qubits until this many bits are
+	// sifted; Bits then only sizes the first batch
+	TargetSiftedBits int `json:"targetSiftedBits"`
+
+	EncryptionConfig *EncryptionConfig `json:"encryptionConfig"`
 }
 
 type EncryptRequest struct {
 	Plaintext string `json:"plaintext"`
 	Sender    string `json:"sender"`
+	Room      string `json:"room"` // Optional; edits keep the room of the original
+
+	PlaintextEncoding PlaintextEncoding `json:"plaintextEncoding"` // text (default) or base64
 }
 
 type EncryptResponse struct {
 	Ciphertext string `json:"ciphertext"`
 }
 
+type MetaRequest struct {
+	Sender string `json:"sender"`
+	Type   string `json:"type"`
+}
+
 type DecryptRequest struct {
 	Ciphertext string `json:"ciphertext"`
+	Sender     string `json:"sender"`
+	Room       string `json:"room"`
+	Offset     int    `json:"offset"`
+	MAC        string `json:"mac"`
+	Compressed bool   `json:"compressed"`
+	Cascade    bool   `json:"cascade"`
+	Watermark  bool   `json:"watermark"`
+
+	MacAlgorithm      MacAlgorithm      `json:"macAlgorithm"`
+	PlaintextEncoding PlaintextEncoding `json:"plaintextEncoding"` // Encoding of the returned plaintext
 }
 
 type DecryptResponse struct {
-	Plaintext string `json:"plaintext"`
+	Plaintext         string            `json:"plaintext"`
+	PlaintextEncoding PlaintextEncoding `json:"plaintextEncoding,omitempty"`
+}
+
+// DecryptedEntry is a stored message together with its decryption outcome
+type DecryptedEntry struct {
+	Message
+	Plaintext string `json:"plaintext,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// InitializeProtocol runs the named QKD protocol and stores the resulting
+// session, whose channel uses the given options. An aborted run returns its
+// diagnostics and no session.
+func InitializeProtocol(ctx context.Context, sessionID string, name ProtocolName, bits int, opts ProtocolOptions, channel ChannelOptions, progress ProgressFunc) (*Session, *ProtocolResult, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	// Re-keys carry the QBER history and phase log forward so trends stay
+	// visible
+	previous, err := sessions.Admit(sessionID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	qkd, err := NewQKDProtocol(name, bits)
+	if err != nil {
+		return nil, nil, err
+	}
+	protocol := qkd.State()
+	protocol.ProtocolOptions = opts
+	protocol.Rand = NewRetryRandSource(entropySource, randRetries)
+	protocol.OnProgress = progress
+	result, err := qkd.RunProtocolWithResult(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if result.Aborted {
+		if previous != nil {
+			previous.recordTransitions(protocol.transitions)
+			if !result.interrupted() {
+				previous.recordQBER(result)
+			}
+		}
+		return nil, result, nil
+	}
+
+	audit := newSessionAuditLog(auditLogger)
+	protocol.SecureChannel.ChannelOptions = channel
+	protocol.SecureChannel.Audit = audit
+	session := &Session{
+		ID:        sessionID,
+		Protocol:  protocol,
+		Channel:   protocol.SecureChannel,
+		Result:    result,
+		CreatedAt: time.Now().UTC(),
+		audit:     audit,
+	}
+	if previous != nil {
+		session.qberHistory = previous.QBERHistory()
+		session.phaseLog = previous.PhaseLog()
+	}
+	session.recordQBER(result)
+	session.recordTransitions(protocol.transitions)
+
+	keyBits := len(protocol.SharedKey)
+	appended, err := sessions.Store(session, previous)
+	if err != nil {
+		return nil, nil, err
+	}
+	eventType := AuditKeyGenerated
+	switch {
+	case appended:
+		eventType = AuditKeyAppended
+	case previous != nil:
+		eventType = AuditRekey
+	}
+	if err := session.audit.Record(AuditEvent{Type: eventType, KeyBits: keyBits}); err != nil {
+		log.Printf("audit: %v", err)
+	}
+	return session, result, nil
 }
 
-// InitializeProtocol initializes the BB84 protocol
-func InitializeProtocol(bits int) error {
+// ImportKey creates a session from a preshared key instead of a protocol run
+func ImportKey(sessionID string, key []int) (*Session, error) {
 	mutex.Lock()
 	defer mutex.Unlock()
 
-	activeProtocol = NewBB84Protocol(bits)
-	if err := activeProtocol.RunProtocol(); err != nil {
-		return err
+	previous, err := sessions.Admit(sessionID)
+	if err != nil {
+		return nil, err
 	}
+	channel, err := NewPresharedChannel(key, presharedKDF)
+	if err != nil {
+		return nil, err
+	}
+	protocol := NewBB84Protocol(0)
+	protocol.SharedKey = key
+	protocol.SecureChannel = channel
+	audit := newSessionAuditLog(auditLogger)
+	protocol.SecureChannel.ChannelOptions = channelOptions
+	protocol.SecureChannel.Audit = audit
+
+	result := &ProtocolResult{SiftedLength: len(key)}
+	result.Distillation.SecureBits = len(key)
+	protocol.phase = PhaseComplete
+	protocol.recordTransition(PhaseCreated, result)
+	session := &Session{
+		ID:        sessionID,
+		Protocol:  protocol,
+		Channel:   protocol.SecureChannel,
+		Result:    result,
+		Imported:  true,
+		CreatedAt: time.Now().UTC(),
+		audit:     audit,
+	}
+	if previous != nil {
+		session.qberHistory = previous.QBERHistory()
+		session.phaseLog = previous.PhaseLog()
+	}
+	session.recordTransitions(protocol.transitions)
+	appended, err := sessions.Store(session, previous)
+	if err != nil {
+		return nil, err
+	}
+
+	eventType := AuditKeyImported
+	if appended {
+		eventType = AuditKeyAppended
+	}
+	if err := session.audit.Record(AuditEvent{Type: eventType, KeyBits: len(key)}); err != nil {
+		log.Printf("audit: %v", err)
+	}
+	return session, nil
+}
 
-	secureChannel = NewSecureChannel(activeProtocol.SharedKey)
+// checkBits checks a requested bit count against the configured cap
+func checkBits(bits int) error {
+	if bits < 0 || bits > maxBits {
+		return fmt.Errorf("bits must be between 0 and %d", maxBits)
+	}
+	return nil
+}
+
+// validBits is checkBits for a handler, writing an error response if the
+// count is out of range
+func validBits(c *gin.Context, bits int) bool {
+	if err := checkBits(bits); err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return false
+	}
+	return true
+}
+
+// checkProtocol checks that a requested protocol name is known
+func checkProtocol(name ProtocolName) error {
+	if !name.known() {
+		return fmt.Errorf("unknown protocol %q", name)
+	}
 	return nil
 }
 
+// validProtocol is checkProtocol for a handler, writing an error response
+// if the protocol is unknown
+func validProtocol(c *gin.Context, name ProtocolName) bool {
+	if err := checkProtocol(name); err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
+		return false
+	}
+	return true
+}
+
+// sessionFromRequest looks up the session named by the sessionId query
+// parameter, writing an error response if it does not exist
+func sessionFromRequest(c *gin.Context) (*Session, bool) {
+	session, err := sessions.Get(c.DefaultQuery("sessionId", defaultSessionID))
+	if errors.Is(err, ErrSessionEvicted) {
+		respondError(c, http.StatusGone, "Session was evicted; initialize it again")
+		return nil, false
+	}
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "Secure channel not initialized")
+		return nil, false
+	}
+	return session, true
+}
+
 // API Endpoints
 
 // Initialize the protocol
 func initializeProtocolHandler(c *gin.Context) {
 	var req InitRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		respondError(c, http.StatusBadRequest, "Invalid request format")
+		return
+	}
+	if !validBits(c, req.Bits) {
 		return
 	}
 
-	if err := InitializeProtocol(req.Bits); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to initialize protocol"})
+	if !validProtocol(c, req.Protocol) {
+		return
+	}
+	if req.TargetSiftedBits < 0 || req.TargetSiftedBits > maxBits {
+		respondError(c, http.StatusBadRequest, fmt.Sprintf("targetSiftedBits must be between 0 and %d", maxBits))
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Protocol initialized successfully", "sharedKey": activeProtocol.SharedKey})
+	// A sifted-bit target stands for the raw bits expected to reach it
+	bits := req.Bits
+	opts := protocolOptions
+	if req.TargetSiftedBits > 0 {
+		opts.TargetSiftedBits = req.TargetSiftedBits
+		info, _ := lookupProtocol(req.Protocol)
+		bits = max(bits, int(math.Ceil(float64(req.TargetSiftedBits)/info.SiftingYield)))
+	}
+	channel, ok := validEncryptionConfig(c, req.EncryptionConfig, req.Protocol, bits)
+	if !ok {
+		return
+	}
+
+	runProtocol(c, req.Protocol, req.Bits, opts, channel)
+}
+
+// runProtocol initializes the requested session and writes the protocol result
+func runProtocol(c *gin.Context, name ProtocolName, bits int, opts ProtocolOptions, channel ChannelOptions) {
+	ctx, cancel := protocolContext(c)
+	defer cancel()
+
+	session, result, err := InitializeProtocol(ctx, c.DefaultQuery("sessionId", defaultSessionID), name, bits, opts, channel, nil)
+	status, body := protocolResponse(session, result, err)
+	if status != http.StatusOK {
+		body = errorBody(c, status, body)
+	}
+	signResponse(c, body)
+	c.JSON(status, body)
+}
+
+// protocolContext bounds a protocol run by the request and the configured time budget
+func protocolContext(c *gin.Context) (context.Context, context.CancelFunc) {
+	return boundedContext(c.Request.Context())
+}
+
+// boundedContext bounds a protocol run by parent and the configured time budget
+func boundedContext(parent context.Context) (context.Context, context.CancelFunc) {
+	if protocolTimeout > 0 {
+		return context.WithTimeout(parent, protocolTimeout)
+	}
+	return context.WithCancel(parent)
+}
+
+// protocolResponse builds the status and body reporting a protocol run
+func protocolResponse(session *Session, result *ProtocolResult, err error) (int, gin.H) {
+	switch {
+	case errors.Is(err, ErrSessionExists), errors.Is(err, ErrKeyNotExtendable), errors.Is(err, ErrSessionQuarantined):
+		return http.StatusConflict, gin.H{"error": err.Error()}
+	case err != nil:
+		return http.StatusInternalServerError, gin.H{"error": "Failed to initialize protocol"}
+	}
+	if result.Aborted {
+		status := http.StatusConflict
+		if result.interrupted() {
+			status = http.StatusServiceUnavailable
+		}
+		return status, gin.H{
+			"error":        "Protocol aborted",
+			"aborted":      true,
+			"reason":       result.Reason,
+			"qber":         result.Qber,
+			"siftedLength": result.SiftedLength,
+			"distillation": result.Distillation,
+		}
+	}
+
+	resp := gin.H{
+		"message":      "Protocol initialized successfully",
+		"sessionId":    session.ID,
+		"protocol":     session.Protocol.Name(),
+		"aborted":      false,
+		"qber":         result.Qber,
+		"siftedLength": result.SiftedLength,
+		"distillation": result.Distillation,
+	}
+	if mode.DebugEnabled() {
+		resp["sharedKey"] = session.Protocol.SharedKey
+	}
+	return http.StatusOK, resp
 }
 
 // Encrypt a message
 func encryptHandler(c *gin.Context) {
 	var req EncryptRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		respondError(c, http.StatusBadRequest, "Invalid request format")
+		return
+	}
+
+	session, ok := sessionFromRequest(c)
+	if !ok {
+		return
+	}
+
+	sender, ok := resolveSender(c, req.Sender)
+	if !ok {
+		return
+	}
+
+	room, err := normalizeRoom(req.Room)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	if secureChannel == nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Secure channel not initialized"})
+	plaintext, ok := requestPlaintext(c, req)
+	if !ok {
 		return
 	}
 
-	msg, err := secureChannel.EncryptMessage(req.Plaintext, req.Sender)
+	msg, err := session.Channel.EncryptInRoom(plaintext, sender, room)
+	if errors.Is(err, ErrSessionQuarantined) {
+		respondError(c, http.StatusLocked, "Session is quarantined")
+		return
+	}
+	if errors.Is(err, ErrEmptyPlaintext) {
+		respondError(c, http.StatusBadRequest, "Plaintext must not be empty")
+		return
+	}
+	if errors.Is(err, ErrMessageTooLong) {
+		respondError(c, http.StatusRequestEntityTooLarge, err.Error())
+		return
+	}
+	if errors.Is(err, ErrQBERNotChecked) {
+		respondError(c, http.StatusConflict, "Key was never checked for eavesdropping")
+		return
+	}
+	if errors.Is(err, ErrKeyExhausted) {
+		respondError(c, http.StatusConflict, err.Error())
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encrypt message"})
+		respondError(c, http.StatusInternalServerError, "Failed to encrypt message")
 		return
 	}
 
@@ -94,61 +444,322 @@ func encryptHandler(c *gin.Context) {
 func decryptHandler(c *gin.Context) {
 	var req DecryptRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		respondError(c, http.StatusBadRequest, "Invalid request format")
+		return
+	}
+
+	if !validPlaintextEncoding(c, req.PlaintextEncoding) {
 		return
 	}
 
-	if secureChannel == nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Secure channel not initialized"})
+	session, ok := sessionFromRequest(c)
+	if !ok {
 		return
 	}
 
 	msg := &Message{
 		Ciphertext: req.Ciphertext,
+		Sender:     req.Sender,
+		Room:       req.Room,
+		Offset:     req.Offset,
+		MAC:        req.MAC,
+		Compressed: req.Compressed,
+		Cascade:    req.Cascade,
+		Watermark:  req.Watermark,
+
+		MacAlgorithm: req.MacAlgorithm,
 	}
 
-	plaintext, err := secureChannel.DecryptMessage(msg)
+	plaintext, err := session.Channel.DecryptMessage(msg)
+	if errors.Is(err, ErrSessionQuarantined) {
+		respondError(c, http.StatusLocked, "Session is quarantined")
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decrypt message"})
+		decryptMonitor.RecordFailure(session.ID, msg.Sender, err)
+		respondError(c, http.StatusInternalServerError, "Failed to decrypt message")
+		return
+	}
+
+	c.JSON(http.StatusOK, DecryptResponse{
+		Plaintext:         encodePlaintext(plaintext, req.PlaintextEncoding),
+		PlaintextEncoding: req.PlaintextEncoding,
+	})
+}
+
+// Verify a message's MAC without decrypting it
+func verifyHandler(c *gin.Context) {
+	var msg Message
+	if err := c.ShouldBindJSON(&msg); err != nil {
+		respondError(c, http.StatusBadRequest, "Invalid request format")
+		return
+	}
+
+	session, ok := sessionFromRequest(c)
+	if !ok {
 		return
 	}
 
-	c.JSON(http.StatusOK, DecryptResponse{Plaintext: plaintext})
+	err := session.Channel.VerifyMessage(&msg)
+	if errors.Is(err, ErrSessionQuarantined) {
+		respondError(c, http.StatusLocked, "Session is quarantined")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"authenticated": err == nil})
+}
+
+// Record a metadata event such as typing or presence
+func metaHandler(c *gin.Context) {
+	var req MetaRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Type == "" {
+		respondError(c, http.StatusBadRequest, "Invalid request format")
+		return
+	}
+
+	session, ok := sessionFromRequest(c)
+	if !ok {
+		return
+	}
+
+	sender, ok := resolveSender(c, req.Sender)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, session.Channel.RecordMeta(sender, req.Type))
 }
 
 func getMessagesHandler(c *gin.Context) {
-	mutex.Lock()
-	defer mutex.Unlock()
+	session, ok := sessionFromRequest(c)
+	if !ok {
+		return
+	}
+
+	// ?room= restricts the listing to one room; an empty value selects
+	// messages sent without a room
+	room, inRoom := c.GetQuery("room")
+	if inRoom {
+		var err error
+		if room, err = normalizeRoom(room); err != nil {
+			respondError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
 
-	if secureChannel == nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Secure channel not initialized"})
+	// Without pagination parameters the full history is returned. Either way
+	// messages come back sorted by sequence number, oldest first.
+	if c.Query("limit") == "" && c.Query("before") == "" {
+		var history []Message
+		if inRoom {
+			history = session.Channel.RoomHistory(room)
+		} else {
+			history = session.Channel.History()
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"messages": history,
+		})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"messages": secureChannel.Messages,
-	})
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultPageSize)))
+	if err != nil || limit <= 0 || limit > maxPageSize {
+		respondError(c, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxPageSize))
+		return
+	}
+	before, err := strconv.Atoi(c.DefaultQuery("before", "0"))
+	if err != nil || before < 0 {
+		respondError(c, http.StatusBadRequest, "before must be a sequence number")
+		return
+	}
+
+	var page []Message
+	var next int
+	if inRoom {
+		page, next = session.Channel.RoomPage(room, before, limit)
+	} else {
+		page, next = session.Channel.Page(before, limit)
+	}
+	resp := gin.H{"messages": page, "nextCursor": nil}
+	if next > 0 {
+		resp["nextCursor"] = next
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// Decrypt the full message history of a session, reporting failures per message
+func decryptedHistoryHandler(c *gin.Context) {
+	session, ok := sessionFromRequest(c)
+	if !ok {
+		return
+	}
+
+	history := session.Channel.History()
+	entries := make([]DecryptedEntry, 0, len(history))
+	for i := range history {
+		entry := DecryptedEntry{Message: history[i]}
+		if history[i].Kind == KindEncrypted {
+			plaintext, err := session.Channel.DecryptMessage(&history[i])
+			if err != nil {
+				decryptMonitor.RecordFailure(session.ID, history[i].Sender, err)
+				entry.Error = err.Error()
+			} else {
+				entry.Plaintext = plaintext
+			}
+		}
+		entries = append(entries, entry)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"sessionId": session.ID, "messages": entries})
+}
+
+// Return the QBER measured by every protocol run in a session
+func qberHistoryHandler(c *gin.Context) {
+	session, ok := sessionFromRequest(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"sessionId": session.ID, "history": session.QBERHistory()})
 }
 
 // Main Function
 func main() {
+	checkConfig := flag.Bool("check-config", false, "validate the configuration and the files it names, then exit")
+	pprofAddr := flag.String("pprof", "", "serve net/http/pprof on this address, such as localhost:6060, behind the admin token")
+	flag.Parse()
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+	if *checkConfig {
+		if err := cfg.CheckResources(); err != nil {
+			log.Fatalf("invalid configuration: %v", err)
+		}
+		log.Printf("configuration is valid")
+		return
+	}
+	mode = cfg.Mode
+	channelOptions = cfg.Channel
+	protocolOptions = cfg.Protocol
+	maxBits = cfg.MaxBits
+	protocolTimeout = cfg.ProtocolTimeout
+	sessions.MaxSessions = cfg.MaxSessions
+	sessions.DuplicatePolicy = cfg.DuplicateSessions
+	randRetries = cfg.RandRetries
+	adminToken = cfg.AdminToken
+	jsonNamingStyle = cfg.JSONNaming
+	presharedKDF = cfg.PresharedKDF
+	maxSenderLength = cfg.MaxSenderLength
+	decryptMonitor = NewDecryptMonitor(cfg.DecryptAlertThreshold, cfg.DecryptAlertWindow, cfg.DecryptAlertWebhook)
+
+	if cfg.AuditLogPath != "" {
+		fileLogger, err := NewFileAuditLogger(cfg.AuditLogPath)
+		if err != nil {
+			panic(err)
+		}
+		defer fileLogger.Close()
+		auditLogger = fileLogger
+	}
+
+	if cfg.SigningKeyPath != "" {
+		if signingKey, err = LoadSigningKey(cfg.SigningKeyPath); err != nil {
+			log.Fatalf("signing key: %v", err)
+		}
+	}
+
+	if cfg.RandSourcePath != "" {
+		device, err := OpenDeviceRandSource(cfg.RandSourcePath)
+		if err != nil {
+			log.Fatalf("random source: %v", err)
+		}
+		entropySource, entropyReader = device, device
+		log.Printf("using %s for key generation entropy", cfg.RandSourcePath)
+	}
+
+	if cfg.EntropySampleBits > 0 {
+		entropySelfTest = RunEntropySelfTest(entropyReader, cfg.EntropySampleBits)
+		if !entropySelfTest.Passed {
+			log.Printf("WARNING: entropy self-test failed, keys may be weak: %+v", entropySelfTest)
+		}
+	}
+
+	if *pprofAddr != "" {
+		servePprof(*pprofAddr)
+	}
+	if cfg.GRPCAddr != "" {
+		serveGRPC(cfg.GRPCAddr, cfg.AuthTokens, cfg.TLSCertFile, cfg.TLSKeyFile)
+	}
+
 	r := gin.Default()
 
 	// Configure CORS
 	config := cors.DefaultConfig()
-	config.AllowOrigins = []string{"*"} // In production, replace with specific origin
+	config.AllowOrigins = cfg.CORSOrigins
+	if len(config.AllowOrigins) == 0 {
+		config.AllowOrigins = []string{"*"} // Only reachable in development mode
+	}
 	config.AllowMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "HEAD", "OPTIONS"}
-	config.AllowHeaders = []string{"Origin", "Content-Length", "Content-Type"}
+	config.AllowHeaders = []string{"Origin", "Content-Length", "Content-Type", "Authorization"}
+	config.ExposeHeaders = []string{signatureHeader}
 
 	r.Use(cors.New(config))
+	r.Use(compressResponses(compressionThreshold))
+	r.Use(jsonNaming(jsonNamingStyle))
+	r.Use(redactResponses(cfg.Redaction, cfg.RedactFields))
 
 	// Your existing routes
 	r.POST("/initialize", initializeProtocolHandler)
-	r.POST("/encrypt", encryptHandler)
+	r.GET("/initialize/stream", initializeStreamHandler)
+	auth := authenticate(cfg.AuthTokens)
+	r.POST("/encrypt", auth, encryptHandler)
 	r.POST("/decrypt", decryptHandler)
+	r.POST("/verify", verifyHandler)
+	r.POST("/meta", auth, metaHandler)
+	r.POST("/self-test", auth, selfTestHandler)
 	r.GET("/messages", getMessagesHandler)
+	r.GET("/messages/ws", messagesWebSocketHandler(cfg.CORSOrigins))
+	r.PUT("/messages/:id", auth, editMessageHandler)
+	r.GET("/history/decrypted", decryptedHistoryHandler)
+	r.GET("/qber-history", qberHistoryHandler)
+	r.GET("/session-log", sessionLogHandler)
+	r.GET("/info-analysis", infoAnalysisHandler)
+	r.POST("/attack/intercept-resend", interceptResendHandler)
+	r.GET("/metrics", metricsHandler)
+	r.GET("/health", healthHandler)
+	r.GET("/status", statusHandler)
+	r.GET("/protocols", protocolsHandler)
+	r.GET("/estimate-bits", estimateBitsHandler)
+	r.GET("/match-mask", matchMaskHandler)
+	r.GET("/report", reportHandler)
+	r.GET("/pubkey", pubkeyHandler)
+	r.GET("/fingerprint.png", fingerprintQRHandler)
+
+	// Debug endpoints expose key material and are disabled in production
+	debug := r.Group("/", requireDebugMode())
+	debug.GET("/trace.csv", traceCSVHandler)
+	debug.POST("/inject-error", injectErrorHandler)
+	debug.GET("/key-diff", keyDiffHandler)
+	debug.GET("/bob-measurements", bobMeasurementsHandler)
+	debug.GET("/key-bases", keyBasesHandler)
+	debug.GET("/circuit", circuitHandler)
+	debug.GET("/quantum-channel", quantumChannelHandler)
+	debug.GET("/bench/throughput", throughputHandler)
+
+	// Admin endpoints handle raw key material and require the admin token
+	admin := r.Group("/", requireAdmin())
+	admin.GET("/key.pem", keyPEMHandler)
+	admin.GET("/keyfile", keyfileHandler)
+	admin.POST("/import-key", importKeyHandler)
+	admin.POST("/admin/quarantine", quarantineHandler)
+	admin.GET("/admin/forensic-dump", forensicDumpHandler)
 
-	if err := r.Run(":8080"); err != nil {
+	if cfg.TLSCertFile != "" {
+		err = r.RunTLS(":8080", cfg.TLSCertFile, cfg.TLSKeyFile)
+	} else {
+		err = r.Run(":8080")
+	}
+	if err != nil {
 		panic(err)
 	}
 }
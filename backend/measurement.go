@@ -0,0 +1,24 @@
+package main
+
+// MeasurementTiming selects whether Bob announces his bases after measuring
+// every qubit, as BB84 requires, or before the qubits are sent
+type MeasurementTiming int
+
+const (
+	MeasureThenAnnounce MeasurementTiming = iota // Bases stay private until every qubit is measured
+	AnnounceThenMeasure                          // Bases are public while the qubits are in flight
+)
+
+func (t MeasurementTiming) String() string {
+	if t == AnnounceThenMeasure {
+		return "announce-first"
+	}
+	return "measure-first"
+}
+
+// basesPublicInFlight reports whether an eavesdropper on the quantum channel
+// already knows Bob's basis for each qubit. The sifted positions are the
+// same either way; only what Eve can learn changes.
+func (bb84 *BB84Protocol) basesPublicInFlight() bool {
+	return bb84.MeasurementTiming == AnnounceThenMeasure
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+// siftSeeded runs the quantum phase of a seeded protocol under timing
+func siftSeeded(t *testing.T, bits int, seed int64, timing MeasurementTiming, interceptResend bool) *BB84Protocol {
+	t.Helper()
+	bb84 := NewBB84Protocol(bits)
+	bb84.MeasurementTiming = timing
+	bb84.InterceptResend = interceptResend
+	bb84.Rand = NewSeededRandSource(seed)
+	if err := bb84.RunUntilSifted(context.Background()); err != nil {
+		t.Fatalf("%v: RunUntilSifted: %v", timing, err)
+	}
+	return bb84
+}
+
+// siftedErrorRate is the fraction of sifted positions where Bob's bit
+// differs from Alice's
+func siftedErrorRate(bb84 *BB84Protocol) float64 {
+	mismatched := 0
+	for i, bit := range bb84.siftedKey {
+		if bb84.bobSiftedKey[i] != bit {
+			mismatched++
+		}
+	}
+	return float64(mismatched) / float64(len(bb84.siftedKey))
+}
+
+// TestMeasurementTimingKeepsSiftedResult checks that announcing Bob's bases
+// before or after measurement sifts the same positions and bits for the
+// same seed, with and without an intercept-resend attacker
+func TestMeasurementTimingKeepsSiftedResult(t *testing.T) {
+	for _, interceptResend := range []bool{false, true} {
+		for _, seed := range []int64{1, 2, 3} {
+			after := siftSeeded(t, 2000, seed, MeasureThenAnnounce, interceptResend)
+			before := siftSeeded(t, 2000, seed, AnnounceThenMeasure, interceptResend)
+			if fmt.Sprint(after.siftedIndices) != fmt.Sprint(before.siftedIndices) {
+				t.Errorf("seed %d, intercept %v: sifted positions differ between timings", seed, interceptResend)
+			}
+			if fmt.Sprint(after.siftedKey) != fmt.Sprint(before.siftedKey) {
+				t.Errorf("seed %d, intercept %v: sifted keys differ between timings", seed, interceptResend)
+			}
+		}
+	}
+}
+
+// TestMeasurementTimingChangesWhatEveLearns checks that only the
+// eavesdropper's error rate depends on the timing: she disturbs a quarter
+// of the sifted bits when the bases are private and none when they are
+// announced while the qubits are in flight
+func TestMeasurementTimingChangesWhatEveLearns(t *testing.T) {
+	for _, timing := range []MeasurementTiming{MeasureThenAnnounce, AnnounceThenMeasure} {
+		bb84 := siftSeeded(t, 20000, 4, timing, true)
+		want := expectedInterceptResendQBER(bb84.PrepFlawProbability, timing)
+		if got := siftedErrorRate(bb84); got < want-0.02 || got > want+0.02 {
+			t.Errorf("%v: intercept-resend error rate %.3f, want about %.3f", timing, got, want)
+		}
+	}
+}
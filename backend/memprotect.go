@@ -0,0 +1,125 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+)
+
+// memoryAEAD seals messages stored by channels with MemoryProtection. Its
+// key is generated once per process and never leaves it, so a memory dump
+// or a leaked message slice reveals no metadata without the running process.
+var memoryAEAD = sync.OnceValues(func() (cipher.AEAD, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate memory protection key: %v", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+})
+
+// sealedMessage is a stored message encrypted under the memory key. Only
+// its sequence number stays in the clear, for lookups.
+type sealedMessage struct {
+	seq  int
+	data []byte // nonce || AES-GCM(JSON(Message))
+}
+
+// sealedAAD binds a sealed record to its channel and position, so records
+// cannot be swapped between channels or reordered
+func (sc *SecureChannel) sealedAAD(seq int) []byte {
+	return strconv.AppendInt([]byte(sc.fingerprint+":"), int64(seq), 10)
+}
+
+func (sc *SecureChannel) seal(msg Message) sealedMessage {
+	aead, err := memoryAEAD()
+	if err != nil {
+		panic(err)
+	}
+	plain, err := json.Marshal(msg)
+	if err != nil {
+		panic(fmt.Sprintf("memory protection: %v", err))
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plain)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		panic(fmt.Sprintf("memory protection: %v", err))
+	}
+	return sealedMessage{seq: msg.Seq, data: aead.Seal(nonce, nonce, plain, sc.sealedAAD(msg.Seq))}
+}
+
+// unseal decrypts a record sealed by this process. Failure means memory
+// corruption or tampering, never bad input, so it panics.
+func (sc *SecureChannel) unseal(s sealedMessage) Message {
+	aead, err := memoryAEAD()
+	if err != nil {
+		panic(err)
+	}
+	nonce, body := s.data[:aead.NonceSize()], s.data[aead.NonceSize():]
+	plain, err := aead.Open(nil, nonce, body, sc.sealedAAD(s.seq))
+	if err != nil {
+		panic(fmt.Sprintf("memory protection: stored message %d failed to authenticate", s.seq))
+	}
+	var msg Message
+	if err := json.Unmarshal(plain, &msg); err != nil {
+		panic(fmt.Sprintf("memory protection: %v", err))
+	}
+	return msg
+}
+
+// The accessors below hide whether stored messages are sealed. The caller
+// must hold sc.mu, and MemoryProtection must not change once a message is
+// stored.
+
+func (sc *SecureChannel) messageCount() int {
+	if sc.MemoryProtection {
+		return len(sc.sealed)
+	}
+	return len(sc.Messages)
+}
+
+func (sc *SecureChannel) messageSeq(i int) int {
+	if sc.MemoryProtection {
+		return sc.sealed[i].seq
+	}
+	return sc.Messages[i].Seq
+}
+
+func (sc *SecureChannel) messageAt(i int) Message {
+	if sc.MemoryProtection {
+		return sc.unseal(sc.sealed[i])
+	}
+	return sc.Messages[i]
+}
+
+// putMessage replaces message i, or appends when i is the message count
+func (sc *SecureChannel) putMessage(i int, msg Message) {
+	if sc.MemoryProtection {
+		if i == len(sc.sealed) {
+			sc.sealed = append(sc.sealed, sc.seal(msg))
+		} else {
+			sc.sealed[i] = sc.seal(msg)
+		}
+		return
+	}
+	if i == len(sc.Messages) {
+		sc.Messages = append(sc.Messages, msg)
+	} else {
+		sc.Messages[i] = msg
+	}
+}
+
+// messagesIn returns messages [start, end)
+func (sc *SecureChannel) messagesIn(start, end int) []Message {
+	msgs := make([]Message, end-start)
+	for i := range msgs {
+		msgs[i] = sc.messageAt(start + i)
+	}
+	return msgs
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Mode selects between development conveniences and production safety
+type Mode string
+
+const (
+	ModeDevelopment Mode = "development"
+	ModeProduction  Mode = "production"
+)
+
+// ParseMode converts a QCHAT_ENV value into a Mode, defaulting to development
+func ParseMode(s string) (Mode, error) {
+	switch Mode(s) {
+	case "", ModeDevelopment:
+		return ModeDevelopment, nil
+	case ModeProduction:
+		return ModeProduction, nil
+	default:
+		return "", fmt.Errorf("unknown QCHAT_ENV %q", s)
+	}
+}
+
+// DebugEnabled reports whether endpoints exposing key material are allowed
+func (m Mode) DebugEnabled() bool {
+	return m == ModeDevelopment
+}
+
+// AllowsWildcardCORS reports whether any origin may be accepted
+func (m Mode) AllowsWildcardCORS() bool {
+	return m == ModeDevelopment
+}
+
+// RequiresTLS reports whether the server must be started with TLS
+func (m Mode) RequiresTLS() bool {
+	return m == ModeProduction
+}
+
+// requireDebugMode hides an endpoint unless the server runs in development mode
+func requireDebugMode() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !mode.DebugEnabled() {
+			abortWithError(c, http.StatusNotFound, "Not found")
+			return
+		}
+		c.Next()
+	}
+}
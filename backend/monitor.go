@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Decryption failure reasons used in logs and metrics
+const (
+	FailureMAC       = "mac"
+	FailureMalformed = "malformed"
+	FailureTooLarge  = "too_large"
+	FailureNoKey     = "no_key"
+	FailureOther     = "other"
+)
+
+// failureReason classifies a DecryptMessage error
+func failureReason(err error) string {
+	switch {
+	case errors.Is(err, ErrMACMismatch), errors.Is(err, ErrUnknownMacAlgorithm), errors.Is(err, ErrCascadeOpen), errors.Is(err, ErrWatermarkMismatch):
+		return FailureMAC
+	case errors.Is(err, ErrMalformedCiphertext):
+		return FailureMalformed
+	case errors.Is(err, ErrCiphertextTooLarge):
+		return FailureTooLarge
+	case errors.Is(err, ErrEmptyKey):
+		return FailureNoKey
+	default:
+		return FailureOther
+	}
+}
+
+// DecryptAlert is the payload posted to the alert webhook
+type DecryptAlert struct {
+	SessionID string    `json:"sessionId"`
+	Failures  int       `json:"failures"`
+	Window    string    `json:"window"`
+	Time      time.Time `json:"time"`
+}
+
+// DecryptMonitor counts decryption failures as an intrusion-detection signal
+// and fires a webhook when a session exceeds the alert threshold
+type DecryptMonitor struct {
+	Threshold  int           // Failures per window that trigger an alert; 0 disables alerts
+	Window     time.Duration // Length of the counting window
+	WebhookURL string
+
+	mu      sync.Mutex
+	totals  map[string]int // failures by reason, for /metrics
+	windows map[string]*failureWindow
+	client  *http.Client
+}
+
+// failureWindow counts one session's failures in the current window
+type failureWindow struct {
+	start   time.Time
+	count   int
+	alerted bool
+}
+
+// NewDecryptMonitor creates a monitor; alerts are disabled when threshold is 0
+func NewDecryptMonitor(threshold int, window time.Duration, webhookURL string) *DecryptMonitor {
+	return &DecryptMonitor{
+		Threshold:  threshold,
+		Window:     window,
+		WebhookURL: webhookURL,
+		totals:     make(map[string]int),
+		windows:    make(map[string]*failureWindow),
+		client:     &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// RecordFailure logs a decryption failure and updates counters and alerts
+func (m *DecryptMonitor) RecordFailure(sessionID, sender string, err error) {
+	reason := failureReason(err)
+	log.Printf("decrypt failure in session %q from %q (%s): %v", sessionID, sender, reason, err)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.totals[reason]++
+	if m.Threshold <= 0 || m.WebhookURL == "" {
+		return
+	}
+
+	now := time.Now()
+	w, ok := m.windows[sessionID]
+	if !ok || now.Sub(w.start) > m.Window {
+		w = &failureWindow{start: now}
+		m.windows[sessionID] = w
+	}
+	w.count++
+	if w.count >= m.Threshold && !w.alerted {
+		w.alerted = true
+		alert := DecryptAlert{SessionID: sessionID, Failures: w.count, Window: m.Window.String(), Time: now.UTC()}
+		go m.sendAlert(alert)
+	}
+}
+
+// sendAlert posts an alert to the configured webhook
+func (m *DecryptMonitor) sendAlert(alert DecryptAlert) {
+	body, _ := json.Marshal(alert)
+	resp, err := m.client.Post(m.WebhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("decrypt alert webhook for session %q failed: %v", alert.SessionID, err)
+		return
+	}
+	resp.Body.Close()
+}
+
+// WriteMetrics renders the failure counters in Prometheus text format
+func (m *DecryptMonitor) WriteMetrics(sb *strings.Builder) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	reasons := make([]string, 0, len(m.totals))
+	for reason := range m.totals {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+
+	sb.WriteString("# HELP qchat_decrypt_failures_total Decryption and MAC failures by reason.\n")
+	sb.WriteString("# TYPE qchat_decrypt_failures_total counter\n")
+	for _, reason := range reasons {
+		fmt.Fprintf(sb, "qchat_decrypt_failures_total{reason=%q} %d\n", reason, m.totals[reason])
+	}
+}
+
+// Expose server metrics for Prometheus scraping
+func metricsHandler(c *gin.Context) {
+	var sb strings.Builder
+	decryptMonitor.WriteMetrics(&sb)
+	sessions.Broadcasts.WriteMetrics(&sb)
+	c.Data(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", []byte(sb.String()))
+}
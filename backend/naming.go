@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+// JSONNaming selects the key style of JSON request and response bodies
+type JSONNaming int
+
+const (
+	NamingCamelCase JSONNaming = iota // As declared in the struct tags
+	NamingSnakeCase                   // Keys rewritten at the HTTP boundary
+)
+
+// parseJSONNaming parses QCHAT_JSON_NAMING, defaulting to camelCase
+func parseJSONNaming(s string) (JSONNaming, error) {
+	switch s {
+	case "", "camel":
+		return NamingCamelCase, nil
+	case "snake":
+		return NamingSnakeCase, nil
+	default:
+		return 0, fmt.Errorf("unknown QCHAT_JSON_NAMING %q", s)
+	}
+}
+
+// camelToSnake converts a camelCase key such as "qberHistory" to snake_case.
+// An upper-case letter only starts a new word after a lower-case letter or
+// digit, so keys that are data rather than names, like the bases "Z" and
+// "X", pass through unchanged.
+func camelToSnake(key string) string {
+	var b strings.Builder
+	prev := rune(0)
+	for _, r := range key {
+		if unicode.IsUpper(r) && (unicode.IsLower(prev) || unicode.IsDigit(prev)) {
+			b.WriteByte('_')
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+		prev = r
+	}
+	return b.String()
+}
+
+// snakeToCamel converts a snake_case key such as "session_id" to camelCase
+func snakeToCamel(key string) string {
+	var b strings.Builder
+	upper := false
+	for _, r := range key {
+		if r == '_' {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// dataKeyedFields are the response fields whose object value is keyed by
+// data, such as sender names or bases, rather than by field names. Their
+// keys pass through unchanged; the objects they map to are still renamed.
+var dataKeyedFields = map[string]bool{
+	"senders":          true,
+	"partitionOffsets": true,
+	"basisStats":       true,
+	"counts":           true,
+}
+
+// renameKeys applies rename to every object key in a decoded JSON value
+// except the keys of dataKeyedFields
+func renameKeys(v any, rename func(string) string) any {
+	switch v := v.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for k, val := range v {
+			renamed := rename(k)
+			if data, ok := val.(map[string]any); ok && (dataKeyedFields[k] || dataKeyedFields[renamed]) {
+				for dk, dv := range data {
+					data[dk] = renameKeys(dv, rename)
+				}
+				out[renamed] = data
+				continue
+			}
+			out[renamed] = renameKeys(val, rename)
+		}
+		return out
+	case []any:
+		for i := range v {
+			v[i] = renameKeys(v[i], rename)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// transformJSON rewrites the keys of a JSON document, preserving numbers exactly
+func transformJSON(data []byte, rename func(string) string) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(renameKeys(v, rename))
+}
+
+// namedContentType reports whether a response of this content type has its
+// keys rewritten: JSON bodies, including RFC 7807 errors
+func namedContentType(contentType string) bool {
+	return strings.HasPrefix(contentType, "application/json") || strings.HasPrefix(contentType, problemContentType)
+}
+
+// jsonNaming rewrites JSON bodies between the internal camelCase names and
+// the configured style. Request keys are converted back to camelCase before
+// binding, and response keys are converted after the handler runs.
+func jsonNaming(naming JSONNaming) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if naming == NamingCamelCase || isWebSocketUpgrade(c.Request) || isEventStream(c.Request) {
+			c.Next()
+			return
+		}
+
+		if c.Request.Body != nil {
+			body, err := io.ReadAll(c.Request.Body)
+			if err != nil {
+				abortWithError(c, http.StatusBadRequest, "Invalid request format")
+				return
+			}
+			if converted, err := transformJSON(body, snakeToCamel); err == nil {
+				body = converted
+			}
+			c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		}
+
+		original := c.Writer
+		buffered := &bufferedResponseWriter{ResponseWriter: original, status: http.StatusOK}
+		c.Writer = buffered
+		c.Next()
+		c.Writer = original
+
+		body := buffered.buf.Bytes()
+		if namedContentType(original.Header().Get("Content-Type")) {
+			if converted, err := transformJSON(body, camelToSnake); err == nil {
+				body = converted
+			}
+		}
+		original.WriteHeader(buffered.status)
+		original.Write(body)
+	}
+}
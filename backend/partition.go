@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// maxKeyPartitions is the number of senders a partitioned key is split between
+const maxKeyPartitions = 2
+
+// Errors returned when the key is partitioned by sender
+var (
+	ErrNoKeyPartition = errors.New("no key partition left for sender")
+	ErrKeyPartitioned = errors.New("key is partitioned by sender")
+)
+
+// keyPartition is the key byte range reserved for one sender
+type keyPartition struct {
+	start, end int // Byte range [start, end) of the key
+	offset     int // Next unused byte, absolute within the key
+}
+
+// partition returns the sender's key range, assigning the next free half on
+// first use. The caller must hold sc.mu.
+func (sc *SecureChannel) partition(sender string) (*keyPartition, error) {
+	if p, ok := sc.parts[sender]; ok {
+		return p, nil
+	}
+	if len(sc.parts) >= maxKeyPartitions {
+		return nil, fmt.Errorf("%w: the key is already split between %d senders", ErrNoKeyPartition, maxKeyPartitions)
+	}
+	if sc.parts == nil {
+		sc.parts = make(map[string]*keyPartition, maxKeyPartitions)
+	}
+
+	size := len(sc.keyBytes) / maxKeyPartitions
+	start := len(sc.parts) * size
+	end := start + size
+	if len(sc.parts) == maxKeyPartitions-1 {
+		end = len(sc.keyBytes) // The last half takes any odd byte
+	}
+	p := &keyPartition{start: start, end: end, offset: start}
+	sc.parts[sender] = p
+	return p, nil
+}
+
+// nextOffset returns the key offset at which sender's next n bytes are
+// encrypted, enforcing the reuse policy. The caller must hold sc.mu.
+func (sc *SecureChannel) nextOffset(sender string, n int) (int, error) {
+	if sc.PartitionKeyBySender {
+		p, err := sc.partition(sender)
+		if err != nil {
+			return 0, err
+		}
+		if p.offset+n > p.end {
+			return 0, fmt.Errorf("%w: %d bytes requested, %d remaining in the partition for %q",
+				ErrKeyExhausted, n, p.end-p.offset, sender)
+		}
+		return p.offset, nil
+	}
+
+	if sc.ReusePolicy == PolicyStrict && sc.offset+n > len(sc.keyBytes) {
+		return 0, fmt.Errorf("%w: %d bytes requested, %d remaining; reusing one-time pad bytes "+
+			"lets an eavesdropper XOR ciphertexts together to recover plaintext, so re-key or "+
+			"explicitly opt in to PolicyRepeat", ErrKeyExhausted, n, len(sc.keyBytes)-sc.offset)
+	}
+	return sc.offset, nil
+}
+
+// advance consumes n key bytes for sender after a successful encryption.
+// The caller must hold sc.mu.
+func (sc *SecureChannel) advance(sender string, n int) {
+	if sc.PartitionKeyBySender {
+		sc.parts[sender].offset += n
+		return
+	}
+	sc.offset += n
+}
+
+// partitionRemaining returns the unused bytes across all partitions,
+// counting halves not yet assigned. The caller must hold sc.mu.
+func (sc *SecureChannel) partitionRemaining() int {
+	remaining := len(sc.keyBytes)
+	for _, p := range sc.parts {
+		remaining -= p.offset - p.start
+	}
+	return max(0, remaining)
+}
+
+// PartitionOffsets returns each sender's next unused key offset
+func (sc *SecureChannel) PartitionOffsets() map[string]int {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+
+	offsets := make(map[string]int, len(sc.parts))
+	for sender, p := range sc.parts {
+		offsets[sender] = p.offset
+	}
+	return offsets
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Phase is the step a protocol run has reached
+type Phase string
+
+const (
+	PhaseCreated  Phase = "created"  // Nothing generated yet
+	PhaseSifted   Phase = "sifted"   // Quantum phase done, paused before error estimation
+	PhaseComplete Phase = "complete" // Post-processing finished (successfully or aborted)
+)
+
+// ErrInvalidPhase is returned when a step is attempted out of order
+var ErrInvalidPhase = errors.New("invalid protocol phase")
+
+// phaseState is the serialized form of a paused protocol run
+type phaseState struct {
+	Phase          Phase           `json:"phase"`
+	NumberOfBits   int             `json:"numberOfBits"`
+	Options        ProtocolOptions `json:"options"`
+	AliceBits      []int           `json:"aliceBits"`
+	AliceBases     []Basis         `json:"aliceBases"`
+	BobBases       []Basis         `json:"bobBases"`
+	QuantumChannel []int           `json:"quantumChannel"`
+	BobMeasured    []int           `json:"bobMeasured"`
+}
+
+// Phase returns the step the protocol has reached
+func (bb84 *BB84Protocol) Phase() Phase {
+	return bb84.phase
+}
+
+// MarshalPhase serializes a protocol paused after sifting so it can be stored
+// and resumed later. The output contains raw key material and must be
+// protected like the key itself.
+func (bb84 *BB84Protocol) MarshalPhase() ([]byte, error) {
+	if bb84.sifter != nil {
+		return nil, fmt.Errorf("phase snapshots are not supported for %s", bb84.Name())
+	}
+	if bb84.phase != PhaseSifted {
+		return nil, fmt.Errorf("%w: only a sifted protocol can be serialized, got %q", ErrInvalidPhase, bb84.phase)
+	}
+	return json.Marshal(phaseState{
+		Phase:          bb84.phase,
+		NumberOfBits:   bb84.NumberOfBits,
+		Options:        bb84.ProtocolOptions,
+		AliceBits:      bb84.Alice.bits,
+		AliceBases:     bb84.Alice.bases,
+		BobBases:       bb84.Bob.bases,
+		QuantumChannel: bb84.QuantumChannel,
+		BobMeasured:    bb84.Bob.measuredBits,
+	})
+}
+
+// UnmarshalPhase restores a protocol serialized by MarshalPhase. The sifted
+// key is recomputed from the restored bases, ready for Resume.
+func (bb84 *BB84Protocol) UnmarshalPhase(data []byte) error {
+	if bb84.sifter != nil {
+		return fmt.Errorf("phase snapshots are not supported for %s", bb84.Name())
+	}
+	var state phaseState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("failed to decode protocol state: %v", err)
+	}
+	if state.Phase != PhaseSifted {
+		return fmt.Errorf("%w: cannot restore phase %q", ErrInvalidPhase, state.Phase)
+	}
+	n := state.NumberOfBits
+	if len(state.AliceBits) != n || len(state.AliceBases) != n || len(state.BobBases) != n || len(state.QuantumChannel) != n || len(state.BobMeasured) != n {
+		return fmt.Errorf("%w: protocol state is inconsistent with its bit count", ErrParameterMismatch)
+	}
+
+	bb84.NumberOfBits = n
+	bb84.ProtocolOptions = state.Options
+	bb84.Alice.bits = state.AliceBits
+	bb84.Alice.bases = state.AliceBases
+	bb84.Bob.bases = state.BobBases
+	bb84.QuantumChannel = state.QuantumChannel
+	bb84.Bob.measuredBits = state.BobMeasured
+	bb84.Bob.missed = deadTimeMisses(state.BobBases, state.Options.DeadTimeSlots)
+	bb84.generateSharedKey()
+	bb84.phase = PhaseSifted
+	return nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PlaintextEncoding is how a plaintext is carried in a JSON request or response
+type PlaintextEncoding string
+
+const (
+	EncodingText   PlaintextEncoding = "text"   // Default; the plaintext is the string itself
+	EncodingBase64 PlaintextEncoding = "base64" // Standard base64 of arbitrary bytes, for binary payloads
+)
+
+// valid reports whether e is a known encoding; empty means text
+func (e PlaintextEncoding) valid() bool {
+	return e == "" || e == EncodingText || e == EncodingBase64
+}
+
+// decodePlaintext returns the raw plaintext bytes carried in s
+func decodePlaintext(s string, encoding PlaintextEncoding) (string, error) {
+	if encoding != EncodingBase64 {
+		return s, nil
+	}
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return "", fmt.Errorf("invalid base64 plaintext: %v", err)
+	}
+	return string(data), nil
+}
+
+// encodePlaintext reverses decodePlaintext for a decrypted plaintext
+func encodePlaintext(plaintext string, encoding PlaintextEncoding) string {
+	if encoding != EncodingBase64 {
+		return plaintext
+	}
+	return base64.StdEncoding.EncodeToString([]byte(plaintext))
+}
+
+// validPlaintextEncoding checks a requested plaintext encoding, writing an
+// error response if it is unknown
+func validPlaintextEncoding(c *gin.Context, encoding PlaintextEncoding) bool {
+	if !encoding.valid() {
+		respondError(c, http.StatusBadRequest, "plaintextEncoding must be text or base64")
+		return false
+	}
+	return true
+}
+
+// requestPlaintext decodes the plaintext of an encrypt or edit request,
+// writing an error response if it cannot
+func requestPlaintext(c *gin.Context, req EncryptRequest) (string, bool) {
+	if !validPlaintextEncoding(c, req.PlaintextEncoding) {
+		return "", false
+	}
+	plaintext, err := decodePlaintext(req.Plaintext, req.PlaintextEncoding)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "Plaintext is not valid base64")
+		return "", false
+	}
+	return plaintext, true
+}
+
+// maxDecompressedBytes bounds the plaintext a compressed message may expand
+// to, so a forged or corrupt payload cannot exhaust memory
+const maxDecompressedBytes = 1 << 20
+
+// ErrDecompressedTooLarge is returned when a compressed message expands past
+// maxDecompressedBytes
+var ErrDecompressedTooLarge = errors.New("decompressed message too large")
+
+// compressPlaintext gzips plaintext when CompressBeforeEncrypt is set and
+// reports whether it did. Inputs that gzip's framing would make longer, as
+// most short chat lines are, are left as they are.
+func (sc *SecureChannel) compressPlaintext(plaintext []byte) ([]byte, bool) {
+	if !sc.CompressBeforeEncrypt {
+		return plaintext, false
+	}
+
+	var buf bytes.Buffer
+	w, _ := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if _, err := w.Write(plaintext); err != nil {
+		return plaintext, false
+	}
+	if err := w.Close(); err != nil {
+		return plaintext, false
+	}
+	if buf.Len() >= len(plaintext) {
+		return plaintext, false
+	}
+	return buf.Bytes(), true
+}
+
+// decompressPlaintext reverses compressPlaintext for a message flagged as
+// compressed
+func decompressPlaintext(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to decompress message: %v", ErrMalformedCiphertext, err)
+	}
+	defer r.Close()
+
+	plaintext, err := io.ReadAll(io.LimitReader(r, maxDecompressedBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to decompress message: %v", ErrMalformedCiphertext, err)
+	}
+	if len(plaintext) > maxDecompressedBytes {
+		return nil, ErrDecompressedTooLarge
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/gin-gonic/gin"
+)
+
+// servePprof serves the net/http/pprof profiles on addr, apart from the main
+// API and behind the same admin token as the operator endpoints. It returns
+// immediately; a listener failure is logged rather than fatal.
+func servePprof(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	r := gin.New()
+	r.Use(gin.Recovery(), requireAdmin())
+	r.Any("/debug/pprof/*profile", gin.WrapH(mux))
+
+	go func() {
+		log.Printf("serving pprof on %s", addr)
+		if err := r.Run(addr); err != nil {
+			log.Printf("pprof: %v", err)
+		}
+	}()
+}
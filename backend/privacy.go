@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+// ExtractorType selects the hash used for privacy amplification
+type ExtractorType int
+
+const (
+	ExtractorToeplitz ExtractorType = iota // Toeplitz-matrix universal hash
+	ExtractorSHA256                        // Truncated SHA-256 in counter mode
+)
+
+func (e ExtractorType) String() string {
+	if e == ExtractorSHA256 {
+		return "sha256"
+	}
+	return "toeplitz"
+}
+
+// Extractor compresses a partially secret key into a shorter, uniformly
+// secret one
+type Extractor interface {
+	Extract(key []int, outputLen int) []int
+}
+
+// contextExtractor is implemented by extractors slow enough on long keys to
+// be worth cancelling part way through
+type contextExtractor interface {
+	ExtractContext(ctx context.Context, key []int, outputLen int) ([]int, error)
+}
+
+// toeplitzBlockBits caps the key bits one Toeplitz matrix is applied to.
+// Longer keys are hashed block by block, each block compressed by the same
+// ratio under its own part of the seed, as finite-key QKD implementations
+// do, so the cost grows linearly with the key rather than quadratically.
+const toeplitzBlockBits = 1 << 15
+
+// toeplitzExtractor multiplies the key by a random Toeplitz matrix over GF(2).
+// The matrix is defined by its first row and column, packed into seed.
+type toeplitzExtractor struct {
+	seed []int
+}
+
+func (e *toeplitzExtractor) Extract(key []int, outputLen int) []int {
+	out, _ := e.ExtractContext(context.Background(), key, outputLen)
+	return out
+}
+
+// ExtractContext hashes each block of at most toeplitzBlockBits key bits to
+// its share of outputLen, consuming the seed in order. It returns ctx's
+// error if ctx is done before the last block.
+func (e *toeplitzExtractor) ExtractContext(ctx context.Context, key []int, outputLen int) ([]int, error) {
+	n := len(key)
+	out := make([]int, 0, outputLen)
+	seed := e.seed
+	for start := 0; start < n; start += toeplitzBlockBits {
+		end := min(start+toeplitzBlockBits, n)
+		blockOut := outputLen*end/n - len(out)
+		if blockOut == 0 {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		out = append(out, toeplitzMultiply(seed[:end-start+blockOut-1], key[start:end], blockOut)...)
+		seed = seed[end-start+blockOut-1:]
+	}
+	return out, nil
+}
+
+// toeplitzMultiply computes out[i] = XOR_j T[i][j]·key[j] where
+// T[i][j] = seed[i-j+n-1], 64 bits at a time. Reversing the key turns row i
+// into seed[i:i+n] ANDed with it, so each output bit is the parity of a
+// word-packed window of the seed against the packed reversed key.
+func toeplitzMultiply(seed, key []int, outputLen int) []int {
+	n := len(key)
+	words := (n + 63) / 64
+	reversed := make([]uint64, words)
+	for t := 0; t < n; t++ {
+		reversed[t/64] |= uint64(keyBit(key[n-1-t])) << (t % 64)
+	}
+	packed := make([]uint64, (len(seed)+63)/64+1) // One spare word for the last window's shift
+	for i, bit := range seed {
+		packed[i/64] |= uint64(keyBit(bit)) << (i % 64)
+	}
+
+	out := make([]int, outputLen)
+	for i := range out {
+		q, shift := i/64, uint(i%64)
+		var acc uint64
+		for w := 0; w < words; w++ {
+			window := packed[q+w] >> shift
+			if shift > 0 {
+				window |= packed[q+w+1] << (64 - shift)
+			}
+			acc ^= window & reversed[w]
+		}
+		out[i] = bits.OnesCount64(acc) & 1
+	}
+	return out
+}
+
+// sha256Extractor hashes the key with a block counter and keeps the leading bits
+type sha256Extractor struct{}
+
+func (sha256Extractor) Extract(key []int, outputLen int) []int {
+	keyBytes := convertKeyToBytes(key)
+	out := make([]int, 0, outputLen)
+
+	var counter [4]byte
+	for block := uint32(0); len(out) < outputLen; block++ {
+		binary.BigEndian.PutUint32(counter[:], block)
+		h := sha256.New()
+		h.Write(counter[:])
+		h.Write(keyBytes)
+		for _, b := range h.Sum(nil) {
+			for bit := 7; bit >= 0 && len(out) < outputLen; bit-- {
+				out = append(out, int(b>>uint(bit))&1)
+			}
+		}
+	}
+	return out
+}
+
+// newExtractor builds the extractor for a key of keyLen bits compressed to
+// outputLen bits, drawing any public seed from src
+func newExtractor(typ ExtractorType, src RandSource, keyLen, outputLen int) (Extractor, error) {
+	switch typ {
+	case ExtractorToeplitz:
+		seed := make([]int, keyLen+outputLen-1)
+		for i := range seed {
+			bit, err := src.Bit()
+			if err != nil {
+				return nil, fmt.Errorf("failed to generate extractor seed: %v", err)
+			}
+			seed[i] = bit
+		}
+		return &toeplitzExtractor{seed: seed}, nil
+	case ExtractorSHA256:
+		return sha256Extractor{}, nil
+	default:
+		return nil, fmt.Errorf("unknown extractor type %d", typ)
+	}
+}
+
+// binaryEntropy returns H(p) = -p·log2(p) - (1-p)·log2(1-p)
+func binaryEntropy(p float64) float64 {
+	if p <= 0 || p >= 1 {
+		return 0
+	}
+	return -p*math.Log2(p) - (1-p)*math.Log2(1-p)
+}
+
+// reconciliationLeak is the number of bits error correction discloses on an
+// n-bit key at the given QBER, at the Shannon limit n·H(qber)
+func reconciliationLeak(n int, qber float64) int {
+	return int(math.Ceil(float64(n) * binaryEntropy(qber)))
+}
+
+// secureKeyLength is the asymptotic BB84 secret key length n·(1 - 2·H(qber)),
+// accounting for the information leaked during error correction and to Eve
+func secureKeyLength(n int, qber float64) int {
+	length := n - 2*reconciliationLeak(n, qber)
+	if length < 0 {
+		return 0
+	}
+	return length
+}
+
+// amplifyPrivacy replaces the shared key with its extracted secure form and
+// records the bits given up in the distillation report. It returns ctx's
+// error if ctx is done while a cancellable extractor runs.
+func (bb84 *BB84Protocol) amplifyPrivacy(ctx context.Context, qber float64, report *DistillationReport) error {
+	n := len(bb84.SharedKey)
+	outputLen := secureKeyLength(n, qber)
+	report.ReconciliationLeakBits = min(reconciliationLeak(n, qber), n)
+	report.PrivacyAmplificationBits = n - report.ReconciliationLeakBits - outputLen
+	report.SecureBits = outputLen
+	if outputLen == 0 {
+		bb84.SharedKey = []int{}
+		return nil
+	}
+
+	extractor, err := newExtractor(bb84.Extractor, bb84.Rand, n, outputLen)
+	if err != nil {
+		return err
+	}
+	if e, ok := extractor.(contextExtractor); ok {
+		key, err := e.ExtractContext(ctx, bb84.SharedKey, outputLen)
+		if err != nil {
+			return err
+		}
+		bb84.SharedKey = key
+		return nil
+	}
+	bb84.SharedKey = extractor.Extract(bb84.SharedKey, outputLen)
+	return nil
+}
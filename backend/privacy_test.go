@@ -0,0 +1,121 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// randomBits returns n random bits from r
+func randomBits(r *rand.Rand, n int) []int {
+	b := make([]int, n)
+	for i := range b {
+		b[i] = r.Intn(2)
+	}
+	return b
+}
+
+// naiveToeplitz is the bit-by-bit definition toeplitzMultiply must match
+func naiveToeplitz(seed, key []int, outputLen int) []int {
+	n := len(key)
+	out := make([]int, outputLen)
+	for i := range out {
+		for j := 0; j < n; j++ {
+			out[i] ^= seed[i-j+n-1] & key[j]
+		}
+	}
+	return out
+}
+
+func TestToeplitzMultiplyMatchesDefinition(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, n := range []int{1, 2, 63, 64, 65, 127, 128, 129, 500, 1000} {
+		for _, m := range []int{1, 63, 64, 65, n/2 + 1, n} {
+			key := randomBits(r, n)
+			seed := randomBits(r, n+m-1)
+			got := toeplitzMultiply(seed, key, m)
+			want := naiveToeplitz(seed, key, m)
+			for i := range want {
+				if got[i] != want[i] {
+					t.Fatalf("n=%d m=%d: bit %d = %d, want %d", n, m, i, got[i], want[i])
+				}
+			}
+		}
+	}
+}
+
+func TestExtractorOutputLength(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	for _, typ := range []ExtractorType{ExtractorToeplitz, ExtractorSHA256} {
+		for _, n := range []int{1, 100, 257, toeplitzBlockBits + 1, 3*toeplitzBlockBits - 5} {
+			for _, m := range []int{1, n / 3, n - 1, n} {
+				if m == 0 {
+					continue
+				}
+				extractor, err := newExtractor(typ, NewSeededRandSource(int64(n+m)), n, m)
+				if err != nil {
+					t.Fatal(err)
+				}
+				out := extractor.Extract(randomBits(r, n), m)
+				if len(out) != m {
+					t.Errorf("%v: %d bits to %d gave %d bits", typ, n, m, len(out))
+				}
+				for i, bit := range out {
+					if bit != 0 && bit != 1 {
+						t.Fatalf("%v: bit %d is %d", typ, i, bit)
+					}
+				}
+			}
+		}
+	}
+}
+
+// TestExtractorUniformity checks that extracted bits are balanced overall
+// and at every output position, over many random keys
+func TestExtractorUniformity(t *testing.T) {
+	const (
+		n      = 512
+		m      = 128
+		trials = 2000
+	)
+	r := rand.New(rand.NewSource(3))
+	for _, typ := range []ExtractorType{ExtractorToeplitz, ExtractorSHA256} {
+		ones := make([]int, m)
+		total := 0
+		for trial := 0; trial < trials; trial++ {
+			extractor, err := newExtractor(typ, NewSeededRandSource(int64(trial)), n, m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, bit := range extractor.Extract(randomBits(r, n), m) {
+				ones[i] += bit
+				total += bit
+			}
+		}
+
+		// Allow five standard deviations of a fair coin
+		if p := float64(total) / (trials * m); math.Abs(p-0.5) > 5*math.Sqrt(0.25/(trials*m)) {
+			t.Errorf("%v: fraction of ones is %.4f", typ, p)
+		}
+		for i, c := range ones {
+			if p := float64(c) / trials; math.Abs(p-0.5) > 5*math.Sqrt(0.25/trials) {
+				t.Errorf("%v: position %d is one %.3f of the time", typ, i, p)
+			}
+		}
+	}
+}
+
+func BenchmarkToeplitzExtract(b *testing.B) {
+	const n = 200000
+	r := rand.New(rand.NewSource(4))
+	key := randomBits(r, n)
+	m := n / 2
+	extractor, err := newExtractor(ExtractorToeplitz, NewSeededRandSource(4), n, m)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		extractor.Extract(key, m)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// problemContentType is the RFC 7807 media type for error responses
+const problemContentType = "application/problem+json"
+
+// wantsProblem reports whether the client asked for RFC 7807 errors
+func wantsProblem(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), problemContentType)
+}
+
+// errorBody returns body, whose "error" member holds the message, in the
+// format the client negotiated. A problem+json body carries the message as
+// detail and any other members as extensions, and its content type is set
+// on the response.
+func errorBody(c *gin.Context, status int, body gin.H) gin.H {
+	if !wantsProblem(c.Request) {
+		return body
+	}
+
+	problem := gin.H{
+		"type":     "about:blank",
+		"title":    http.StatusText(status),
+		"status":   status,
+		"detail":   body["error"],
+		"instance": c.Request.URL.Path,
+	}
+	for key, value := range body {
+		if key != "error" {
+			problem[key] = value
+		}
+	}
+	c.Header("Content-Type", problemContentType)
+	return problem
+}
+
+// respondError writes message as an error response in the negotiated format
+func respondError(c *gin.Context, status int, message string) {
+	c.JSON(status, errorBody(c, status, gin.H{"error": message}))
+}
+
+// abortWithError is respondError for middleware, which must also stop the chain
+func abortWithError(c *gin.Context, status int, message string) {
+	c.Abort()
+	respondError(c, status, message)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ProgressStage names a stage of a protocol run
+type ProgressStage string
+
+const (
+	StageGeneratingBits ProgressStage = "generating bits"
+	StageTransmitting   ProgressStage = "transmitting"
+	StageSifting        ProgressStage = "sifting"
+	StageEstimatingQBER ProgressStage = "estimating QBER"
+	StageDone           ProgressStage = "done"
+)
+
+// DefaultTransmissionBatches is how many progress reports the transmitting
+// stage emits when ProtocolOptions leaves TransmissionBatches at zero
+const DefaultTransmissionBatches = 10
+
+// maxTransmissionBatches bounds TransmissionBatches, and with it the progress
+// events a streamed run buffers
+const maxTransmissionBatches = 100
+
+// ProgressEvent reports that a run entered a stage or, while transmitting,
+// that another batch of qubits was sent
+type ProgressEvent struct {
+	Stage ProgressStage `json:"stage"`
+	Sent  int           `json:"sent,omitempty"` // Qubits transmitted so far
+	Total int           `json:"total,omitempty"`
+}
+
+// ProgressFunc is called synchronously as a protocol run enters each stage,
+// and after each batch of qubits is transmitted
+type ProgressFunc func(event ProgressEvent)
+
+// stageStart records when a run entered a stage
+type stageStart struct {
+	stage ProgressStage
+	at    time.Time
+}
+
+// StageTiming is how long a run spent in one stage
+type StageTiming struct {
+	Stage    ProgressStage `json:"stage"`
+	Duration time.Duration `json:"duration"`
+}
+
+// reportProgress records the start of a stage and notifies the progress
+// callback, if any
+func (bb84 *BB84Protocol) reportProgress(stage ProgressStage) {
+	bb84.stageStarts = append(bb84.stageStarts, stageStart{stage: stage, at: time.Now()})
+	if bb84.OnProgress != nil {
+		bb84.OnProgress(ProgressEvent{Stage: stage})
+	}
+}
+
+// transmissionBatchSize returns how many qubits are sent between progress
+// reports, so a run of any length reports about TransmissionBatches times
+func (bb84 *BB84Protocol) transmissionBatchSize() int {
+	batches := bb84.TransmissionBatches
+	if batches <= 0 {
+		batches = DefaultTransmissionBatches
+	}
+	return max(1, (bb84.NumberOfBits+batches-1)/batches)
+}
+
+// reportTransmission notifies the progress callback, if any, that sent of
+// the run's qubits have been transmitted
+func (bb84 *BB84Protocol) reportTransmission(sent int) {
+	if bb84.OnProgress != nil {
+		bb84.OnProgress(ProgressEvent{Stage: StageTransmitting, Sent: sent, Total: bb84.NumberOfBits})
+	}
+}
+
+// StageTimings returns the time spent in each completed stage, in order.
+// A paused or resumed run includes the pause in the sifting stage.
+func (bb84 *BB84Protocol) StageTimings() []StageTiming {
+	timings := make([]StageTiming, 0, len(bb84.stageStarts))
+	for i := 0; i+1 < len(bb84.stageStarts); i++ {
+		timings = append(timings, StageTiming{
+			Stage:    bb84.stageStarts[i].stage,
+			Duration: bb84.stageStarts[i+1].at.Sub(bb84.stageStarts[i].at),
+		})
+	}
+	return timings
+}
+
+// isEventStream reports whether the client asked for server-sent events
+func isEventStream(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+}
+
+// streamOutcome is the final result of a streamed protocol run
+type streamOutcome struct {
+	status int
+	body   gin.H
+}
+
+// Initialize the protocol, streaming a "progress" event as each stage starts
+// and as each batch of qubits is transmitted, then a final "result" event carrying the same body as POST /initialize
+func initializeStreamHandler(c *gin.Context) {
+	bits, err := strconv.Atoi(c.DefaultQuery("bits", "0"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "Invalid request format")
+		return
+	}
+	if !validBits(c, bits) {
+		return
+	}
+
+	name := ProtocolName(c.Query("protocol"))
+	if !validProtocol(c, name) {
+		return
+	}
+
+	sessionID := c.DefaultQuery("sessionId", defaultSessionID)
+	// Holds every stage and transmission batch, so the run never blocks
+	batches := protocolOptions.TransmissionBatches
+	if batches <= 0 {
+		batches = DefaultTransmissionBatches
+	}
+	events := make(chan ProgressEvent, 8+batches)
+	done := make(chan streamOutcome, 1)
+	ctx, cancel := protocolContext(c)
+	defer cancel()
+	go func() {
+		session, result, err := InitializeProtocol(ctx, sessionID, name, bits, protocolOptions, channelOptions, func(event ProgressEvent) {
+			events <- event
+		})
+		close(events)
+		status, body := protocolResponse(session, result, err)
+		done <- streamOutcome{status: status, body: body}
+	}()
+
+	c.Stream(func(w io.Writer) bool {
+		if event, ok := <-events; ok {
+			c.SSEvent("progress", event)
+			return true
+		}
+		outcome := <-done
+		outcome.body["status"] = outcome.status
+		c.SSEvent("result", outcome.body)
+		return false
+	})
+}
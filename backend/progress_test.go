@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+// transmissionReports returns the Sent counts of the batch reports a run
+// makes while transmitting, skipping the event that opens the stage
+func transmissionReports(bb84 *BB84Protocol) *[]int {
+	var sent []int
+	bb84.OnProgress = func(event ProgressEvent) {
+		if event.Stage == StageTransmitting && event.Sent > 0 {
+			sent = append(sent, event.Sent)
+		}
+	}
+	return &sent
+}
+
+// TestTransmissionBatchReports checks that the progress callback fires once
+// per batch and once more when transmission completes, with increasing
+// counts ending at the number of qubits
+func TestTransmissionBatchReports(t *testing.T) {
+	for _, tc := range []struct {
+		bits, batches, want int
+	}{
+		{1000, 0, DefaultTransmissionBatches},
+		{1000, 4, 4},
+		{1005, 10, 10},
+		{999, 100, 100},
+		{5, 10, 5}, // Never more reports than qubits
+		{1, 0, 1},
+	} {
+		bb84 := NewBB84Protocol(tc.bits)
+		bb84.TransmissionBatches = tc.batches
+		bb84.Rand = NewSeededRandSource(1)
+		sent := transmissionReports(bb84)
+		_ = bb84.RunUntilSifted(context.Background()) // Tiny runs may sift too few bits
+
+		if len(*sent) != tc.want {
+			t.Errorf("%d bits in %d batches: %d reports, want %d", tc.bits, tc.batches, len(*sent), tc.want)
+			continue
+		}
+		for i := 1; i < len(*sent); i++ {
+			if (*sent)[i] <= (*sent)[i-1] {
+				t.Errorf("%d bits: report %d sent %d after %d", tc.bits, i, (*sent)[i], (*sent)[i-1])
+			}
+		}
+		if last := (*sent)[len(*sent)-1]; last != tc.bits {
+			t.Errorf("%d bits: last report sent %d", tc.bits, last)
+		}
+	}
+}
+
+// TestTransmissionStopsOnCancel checks that transmission stops at the next
+// batch once the context is cancelled
+func TestTransmissionStopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	bb84 := NewBB84Protocol(10000)
+	bb84.Rand = NewSeededRandSource(2)
+	reports := 0
+	bb84.OnProgress = func(event ProgressEvent) {
+		if event.Stage == StageTransmitting && event.Sent > 0 {
+			reports++
+			cancel()
+		}
+	}
+	if err := bb84.RunUntilSifted(ctx); err == nil {
+		t.Fatal("cancelled run completed")
+	}
+	if reports != 1 {
+		t.Errorf("%d batch reports after cancelling at the first, want 1", reports)
+	}
+}
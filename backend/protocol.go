@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ProtocolName identifies a QKD protocol variant
+type ProtocolName string
+
+const (
+	ProtocolBB84   ProtocolName = "bb84"
+	ProtocolSARG04 ProtocolName = "sarg04"
+)
+
+// QKDProtocol is a key distribution protocol run over BB84's
+// prepare-and-measure hardware. Variants differ only in their classical
+// post-processing, so they share the BB84Protocol transmission state.
+type QKDProtocol interface {
+	Name() ProtocolName
+	RunProtocolWithResult(ctx context.Context) (*ProtocolResult, error)
+	State() *BB84Protocol
+}
+
+// sifter replaces BB84 basis sifting for a protocol variant. It must set
+// siftedIndices, SharedKey, siftedKey and bobSiftedKey.
+type sifter interface {
+	name() ProtocolName
+	sift(bb84 *BB84Protocol) error
+}
+
+// ProtocolInfo describes a registered protocol for discovery
+type ProtocolInfo struct {
+	Name         ProtocolName    `json:"name"`
+	Description  string          `json:"description"`
+	SiftingYield float64         `json:"siftingYield"` // Expected fraction of qubits kept on a noiseless channel
+	Features     map[string]bool `json:"features"`
+
+	new func(bits int) QKDProtocol
+}
+
+// protocols registers every selectable protocol; the first is the default
+var protocols = []ProtocolInfo{
+	{
+		Name:         ProtocolBB84,
+		Description:  "Bennett-Brassard 1984: four states in two bases, sifted by public basis comparison",
+		SiftingYield: 0.5, // Bases match half the time
+		Features: map[string]bool{
+			"decoyStates":           false,
+			"pnsResistant":          false,
+			"phaseSnapshots":        true,
+			"interceptResendAttack": true,
+		},
+		new: func(bits int) QKDProtocol { return NewBB84Protocol(bits) },
+	},
+	{
+		Name:         ProtocolSARG04,
+		Description:  "Scarani-Acin-Ribordy-Gisin 2004: BB84 states, sifted by announcing non-orthogonal state pairs",
+		SiftingYield: 0.25, // Bob's basis must differ from Alice's, then his outcome is conclusive half the time
+		Features: map[string]bool{
+			"decoyStates":           false,
+			"pnsResistant":          true,
+			"phaseSnapshots":        false,
+			"interceptResendAttack": false,
+		},
+		new: func(bits int) QKDProtocol { return NewSARG04Protocol(bits) },
+	},
+}
+
+// lookupProtocol returns the registered protocol with the given name; empty
+// means the default
+func lookupProtocol(name ProtocolName) (ProtocolInfo, bool) {
+	if name == "" {
+		return protocols[0], true
+	}
+	for _, p := range protocols {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return ProtocolInfo{}, false
+}
+
+// known reports whether name selects a registered protocol
+func (name ProtocolName) known() bool {
+	_, ok := lookupProtocol(name)
+	return ok
+}
+
+// NewQKDProtocol creates the named protocol, defaulting to BB84
+func NewQKDProtocol(name ProtocolName, bits int) (QKDProtocol, error) {
+	p, ok := lookupProtocol(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown protocol %q", name)
+	}
+	return p.new(bits), nil
+}
+
+// List the registered protocols with their features and default parameters
+func protocolsHandler(c *gin.Context) {
+	defaults := DefaultProtocolOptions()
+	c.JSON(http.StatusOK, gin.H{
+		"default":   protocols[0].Name,
+		"protocols": protocols,
+		"defaultParameters": gin.H{
+			"qberSampleFraction": defaults.QBERSampleFraction,
+			"qberThreshold":      defaults.QBERThreshold,
+			"extractor":          defaults.Extractor.String(),
+		},
+	})
+}
+
+// Name returns the protocol variant whose sifting rule this run uses
+func (bb84 *BB84Protocol) Name() ProtocolName {
+	if bb84.sifter != nil {
+		return bb84.sifter.name()
+	}
+	return ProtocolBB84
+}
+
+// State returns the underlying transmission state
+func (bb84 *BB84Protocol) State() *BB84Protocol {
+	return bb84
+}
+
+// siftingYield returns the fraction of qubits sifting is expected to keep
+func (bb84 *BB84Protocol) siftingYield() float64 {
+	p, _ := lookupProtocol(bb84.Name())
+	return p.SiftingYield
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Default error-estimation parameters
+const (
+	DefaultQBERSampleFraction = 0.1
+	DefaultQBERThreshold      = 0.11 // Upper bound for a secure BB84 key
+)
+
+// ErrProtocolAborted is returned by RunProtocol when a run is aborted
+var ErrProtocolAborted = errors.New("protocol aborted")
+
+// AbortReason identifies why a protocol run was aborted
+type AbortReason string
+
+const (
+	ReasonEavesdropper         AbortReason = "eavesdropper"
+	ReasonInsufficientKey      AbortReason = "insufficient-key"
+	ReasonReconciliationFailed AbortReason = "reconciliation-failed"
+	ReasonLowKeyEntropy        AbortReason = "low-key-entropy"
+	ReasonDegenerateKey        AbortReason = "degenerate-key"
+	ReasonLowSiftingEfficiency AbortReason = "low-sifting-efficiency"
+	ReasonTimeout              AbortReason = "timeout"  // Time budget exceeded
+	ReasonCanceled             AbortReason = "canceled" // Caller gave up, e.g. the client disconnected
+)
+
+// contextReason maps a done context to the reason for aborting a run
+func contextReason(ctx context.Context) AbortReason {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return ReasonTimeout
+	}
+	return ReasonCanceled
+}
+
+// ProtocolResult holds the diagnostics of a single protocol run
+type ProtocolResult struct {
+	Aborted      bool               `json:"aborted"`
+	Reason       AbortReason        `json:"reason,omitempty"`
+	Qber         float64            `json:"qber"`
+	SiftedLength int                `json:"siftedLength"`
+	Distillation DistillationReport `json:"distillation"`
+}
+
+// DistillationReport breaks down where the raw bits of a run went, from
+// transmission to the final secure key
+type DistillationReport struct {
+	RawBits                  int `json:"rawBits"`
+	SiftedBits               int `json:"siftedBits"`
+	SampledBits              int `json:"sampledBits"`              // Disclosed to estimate QBER
+	ReconciliationPasses     int `json:"reconciliationPasses"`     // CASCADE passes run over Bob's key
+	ResidualErrors           int `json:"residualErrors"`           // Left uncorrected when reconciliation fails
+	ReconciliationLeakBits   int `json:"reconciliationLeakBits"`   // Disclosed by error correction
+	PrivacyAmplificationBits int `json:"privacyAmplificationBits"` // Removed to erase Eve's information
+	SecureBits               int `json:"secureBits"`
+}
+
+func (r *ProtocolResult) abort(reason AbortReason) {
+	r.Aborted = true
+	r.Reason = reason
+}
+
+// interrupted reports whether the run was cut short before measuring QBER
+// to completion, so its diagnostics are partial
+func (r *ProtocolResult) interrupted() bool {
+	return r.Reason == ReasonTimeout || r.Reason == ReasonCanceled
+}
+
+// estimateQBER discloses a sample of the sifted key, compares Alice's bits
+// with Bob's at those positions and removes them from the key. The sample
+// is a prefix unless RandomQBERSample is set.
+func (bb84 *BB84Protocol) estimateQBER() (float64, error) {
+	sampleSize := int(math.Ceil(float64(len(bb84.SharedKey)) * bb84.QBERSampleFraction))
+	if sampleSize == 0 {
+		return 0, nil
+	}
+	if bb84.RandomQBERSample {
+		if err := bb84.moveRandomSampleToFront(sampleSize); err != nil {
+			return 0, err
+		}
+	}
+
+	errorCount := 0
+	for i := 0; i < sampleSize; i++ {
+		if bb84.siftedKey[i] != bb84.bobSiftedKey[i] {
+			errorCount++
+		}
+	}
+
+	bb84.SharedKey = bb84.siftedKey[sampleSize:]
+	bb84.sampleSize = sampleSize
+	return float64(errorCount) / float64(sampleSize), nil
+}
+
+// moveRandomSampleToFront picks size sifted positions uniformly at random
+// and reorders the sifted key, Bob's copy and siftedIndices so they come
+// first, each part keeping its original order. The code that treats the
+// first sampleSize sifted bits as the disclosed ones then holds unchanged,
+// while the disclosed bits are scattered over the whole transmission.
+func (bb84 *BB84Protocol) moveRandomSampleToFront(size int) error {
+	n := len(bb84.siftedKey)
+	positions := make([]int, n)
+	for i := range positions {
+		positions[i] = i
+	}
+	// Partial Fisher-Yates: the first size entries become a uniform sample
+	for i := 0; i < size; i++ {
+		j, err := randomIndex(bb84.Rand, n-i)
+		if err != nil {
+			return fmt.Errorf("failed to choose QBER sample: %v", err)
+		}
+		positions[i], positions[i+j] = positions[i+j], positions[i]
+	}
+	sampled := make([]bool, n)
+	for _, p := range positions[:size] {
+		sampled[p] = true
+	}
+
+	order := make([]int, 0, n)
+	for _, first := range []bool{true, false} {
+		for i := 0; i < n; i++ {
+			if sampled[i] == first {
+				order = append(order, i)
+			}
+		}
+	}
+	key, bob, indices := make([]int, n), make([]int, n), make([]int, n)
+	for i, from := range order {
+		key[i] = bb84.siftedKey[from]
+		bob[i] = bb84.bobSiftedKey[from]
+		indices[i] = bb84.siftedIndices[from]
+	}
+	bb84.siftedKey, bb84.bobSiftedKey, bb84.siftedIndices = key, bob, indices
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+// sampleOnce runs QBER estimation with a random sample over n sifted
+// positions and returns the positions disclosed and those kept in the key
+func sampleOnce(t *testing.T, src RandSource, r *rand.Rand, n int) (sampled, kept []int) {
+	t.Helper()
+	bb84 := NewBB84Protocol(n)
+	bb84.Rand = src
+	bb84.RandomQBERSample = true
+	bb84.QBERSampleFraction = 0.1
+	bb84.siftedKey = randomBits(r, n)
+	bb84.bobSiftedKey = append([]int(nil), bb84.siftedKey...)
+	bb84.SharedKey = bb84.siftedKey
+	bb84.siftedIndices = make([]int, n)
+	for i := range bb84.siftedIndices {
+		bb84.siftedIndices[i] = i
+	}
+	alice := append([]int(nil), bb84.siftedKey...)
+
+	if _, err := bb84.estimateQBER(); err != nil {
+		t.Fatal(err)
+	}
+	sampled, kept = bb84.siftedIndices[:bb84.sampleSize], bb84.siftedIndices[bb84.sampleSize:]
+	for i, pos := range kept {
+		if bb84.SharedKey[i] != alice[pos] {
+			t.Fatalf("key bit %d is not Alice's bit at position %d", i, pos)
+		}
+	}
+	return sampled, kept
+}
+
+// TestRandomQBERSampleIsRemovedFromKey checks that the disclosed positions
+// and the key's positions split the sifted key between them, each in order
+func TestRandomQBERSampleIsRemovedFromKey(t *testing.T) {
+	src, r := NewSeededRandSource(14), rand.New(rand.NewSource(14))
+	for trial := 0; trial < 100; trial++ {
+		sampled, kept := sampleOnce(t, src, r, 200)
+		if len(sampled) != 20 || len(kept) != 180 {
+			t.Fatalf("%d sampled and %d kept of 200", len(sampled), len(kept))
+		}
+		if !sort.IntsAreSorted(sampled) || !sort.IntsAreSorted(kept) {
+			t.Fatal("sample or key lost its original order")
+		}
+		seen := make([]bool, 200)
+		for _, pos := range append(append([]int(nil), sampled...), kept...) {
+			if seen[pos] {
+				t.Fatalf("position %d is both disclosed and kept", pos)
+			}
+			seen[pos] = true
+		}
+	}
+}
+
+// TestRandomQBERSampleIsUniform checks with a chi-squared test that every
+// sifted position is equally likely to be disclosed, and that the sample is
+// not biased towards the contiguous prefix the default mode discloses
+func TestRandomQBERSampleIsUniform(t *testing.T) {
+	const (
+		n      = 50
+		size   = 5 // Ceil of the 10% sample fraction
+		trials = 10000
+		// The 0.1% critical value of chi-squared with n-1 degrees of freedom
+		critical = 85.35
+	)
+	src, r := NewSeededRandSource(15), rand.New(rand.NewSource(15))
+	counts := make([]int, n)
+	prefix := 0
+	for trial := 0; trial < trials; trial++ {
+		sampled, _ := sampleOnce(t, src, r, n)
+		if sampled[len(sampled)-1] == size-1 {
+			prefix++
+		}
+		for _, pos := range sampled {
+			counts[pos]++
+		}
+	}
+
+	expected := float64(trials*size) / n
+	chi2 := 0.0
+	for _, c := range counts {
+		d := float64(c) - expected
+		chi2 += d * d / expected
+	}
+	if chi2 > critical {
+		t.Errorf("chi-squared %.1f over %d positions exceeds %.2f: counts %v", chi2, n, critical, counts)
+	}
+	// One in C(50, 5), about 2e-6, of uniform samples is the prefix
+	if prefix > 1 {
+		t.Errorf("the contiguous prefix was sampled %d times in %d", prefix, trials)
+	}
+}
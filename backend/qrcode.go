@@ -0,0 +1,395 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// QR code parameters. Only versions 1 to 3 at error correction level M are
+// supported: each uses a single Reed-Solomon block and at most one alignment
+// pattern, and version 3 already holds 42 bytes, more than a fingerprint.
+const (
+	qrMaxVersion   = 3
+	qrFormatLevelM = 0 // Format bits of error correction level M
+	qrQuietZone    = 4 // Light border, in modules, required around the symbol
+)
+
+// qrCodewords holds the data and error correction codewords per version at
+// level M, indexed by version
+var qrCodewords = [qrMaxVersion + 1]struct{ data, ec int }{
+	{}, {16, 10}, {28, 16}, {44, 26},
+}
+
+// ErrQRTooLong is returned when the payload does not fit a supported version
+var ErrQRTooLong = errors.New("payload too long for a QR code")
+
+// qrCode is a QR symbol as a square grid of modules, true for dark
+type qrCode struct {
+	size     int
+	modules  [][]bool
+	function [][]bool // Finder, timing, alignment and format modules, which masks skip
+}
+
+// encodeQR encodes data in byte mode in the smallest supported version
+func encodeQR(data []byte) (*qrCode, error) {
+	version := 1
+	for version <= qrMaxVersion && 4+8+8*len(data) > 8*qrCodewords[version].data {
+		version++
+	}
+	if version > qrMaxVersion {
+		return nil, ErrQRTooLong
+	}
+
+	codewords := qrDataCodewords(data, qrCodewords[version].data)
+	codewords = append(codewords, reedSolomonRemainder(codewords, qrCodewords[version].ec)...)
+
+	q := newQRCode(version)
+	q.placeData(codewords)
+
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		q.applyMask(mask)
+		q.drawFormat(mask)
+		if p := q.penalty(); bestPenalty < 0 || p < bestPenalty {
+			best, bestPenalty = mask, p
+		}
+		q.applyMask(mask) // Masking is an XOR, so applying it again undoes it
+	}
+	q.applyMask(best)
+	q.drawFormat(best)
+	return q, nil
+}
+
+// qrDataCodewords builds the byte-mode bit stream for data: mode indicator,
+// 8-bit length, the bytes, a terminator, and the standard pad bytes
+func qrDataCodewords(data []byte, capacity int) []byte {
+	var bits []bool
+	appendBits := func(v, n int) {
+		for i := n - 1; i >= 0; i-- {
+			bits = append(bits, v>>i&1 == 1)
+		}
+	}
+	appendBits(0b0100, 4)
+	appendBits(len(data), 8)
+	for _, b := range data {
+		appendBits(int(b), 8)
+	}
+	appendBits(0, min(4, 8*capacity-len(bits)))
+	for len(bits)%8 != 0 {
+		bits = append(bits, false)
+	}
+
+	codewords := make([]byte, 0, capacity)
+	for i := 0; i < len(bits); i += 8 {
+		var b byte
+		for _, bit := range bits[i : i+8] {
+			b <<= 1
+			if bit {
+				b |= 1
+			}
+		}
+		codewords = append(codewords, b)
+	}
+	for pad := byte(0xec); len(codewords) < capacity; pad ^= 0xec ^ 0x11 {
+		codewords = append(codewords, pad)
+	}
+	return codewords
+}
+
+// gfMultiply multiplies in GF(2^8) modulo the QR polynomial x^8+x^4+x^3+x^2+1
+func gfMultiply(x, y byte) byte {
+	var z byte
+	for i := 7; i >= 0; i-- {
+		z = z<<1 ^ (z>>7)*0x1d
+		z ^= (y >> i & 1) * x
+	}
+	return z
+}
+
+// reedSolomonRemainder returns the degree error correction codewords for data
+func reedSolomonRemainder(data []byte, degree int) []byte {
+	// Generator (x - 1)(x - 2)...(x - 2^(degree-1)), leading coefficient dropped
+	divisor := make([]byte, degree)
+	divisor[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range divisor {
+			divisor[j] = gfMultiply(divisor[j], root)
+			if j+1 < len(divisor) {
+				divisor[j] ^= divisor[j+1]
+			}
+		}
+		root = gfMultiply(root, 2)
+	}
+
+	remainder := make([]byte, degree)
+	for _, b := range data {
+		factor := b ^ remainder[0]
+		copy(remainder, remainder[1:])
+		remainder[degree-1] = 0
+		for i, coef := range divisor {
+			remainder[i] ^= gfMultiply(coef, factor)
+		}
+	}
+	return remainder
+}
+
+// newQRCode draws the function patterns of a version, leaving data modules light
+func newQRCode(version int) *qrCode {
+	size := 17 + 4*version
+	q := &qrCode{size: size, modules: make([][]bool, size), function: make([][]bool, size)}
+	for y := range q.modules {
+		q.modules[y] = make([]bool, size)
+		q.function[y] = make([]bool, size)
+	}
+
+	for i := 0; i < size; i++ {
+		q.set(6, i, i%2 == 0)
+		q.set(i, 6, i%2 == 0)
+	}
+	q.drawFinder(3, 3)
+	q.drawFinder(size-4, 3)
+	q.drawFinder(3, size-4)
+	if version > 1 {
+		q.drawAlignment(size-7, size-7)
+	}
+	q.drawFormat(0) // Reserves the format modules until the mask is chosen
+	return q
+}
+
+// set draws a function module at column x, row y
+func (q *qrCode) set(x, y int, dark bool) {
+	q.modules[y][x] = dark
+	q.function[y][x] = true
+}
+
+// drawFinder draws a finder pattern and its separator centred on x, y
+func (q *qrCode) drawFinder(x, y int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			xx, yy := x+dx, y+dy
+			if xx < 0 || xx >= q.size || yy < 0 || yy >= q.size {
+				continue
+			}
+			d := max(abs(dx), abs(dy))
+			q.set(xx, yy, d != 2 && d != 4)
+		}
+	}
+}
+
+// drawAlignment draws a 5×5 alignment pattern centred on x, y
+func (q *qrCode) drawAlignment(x, y int) {
+	for dy := -2; dy <= 2; dy++ {
+		for dx := -2; dx <= 2; dx++ {
+			q.set(x+dx, y+dy, max(abs(dx), abs(dy)) != 1)
+		}
+	}
+}
+
+// drawFormat draws both copies of the format information for level M and
+// mask, protected by a BCH(15,5) code, and the always-dark module
+func (q *qrCode) drawFormat(mask int) {
+	data := qrFormatLevelM<<3 | mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = rem<<1 ^ (rem>>9)*0x537
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool { return bits>>i&1 == 1 }
+
+	for i := 0; i <= 5; i++ {
+		q.set(8, i, bit(i))
+	}
+	q.set(8, 7, bit(6))
+	q.set(8, 8, bit(7))
+	q.set(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		q.set(14-i, 8, bit(i))
+	}
+	for i := 0; i < 8; i++ {
+		q.set(q.size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		q.set(8, q.size-15+i, bit(i))
+	}
+	q.set(8, q.size-8, true)
+}
+
+// placeData fills the non-function modules with codewords in the zigzag
+// order of the standard: two-column strips from the right, alternately
+// upwards and downwards, skipping the vertical timing pattern
+func (q *qrCode) placeData(codewords []byte) {
+	i := 0
+	for right := q.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		upward := (right+1)&2 == 0
+		for vert := 0; vert < q.size; vert++ {
+			y := vert
+			if upward {
+				y = q.size - 1 - vert
+			}
+			for j := 0; j < 2; j++ {
+				x := right - j
+				if q.function[y][x] || i >= len(codewords)*8 {
+					continue
+				}
+				q.modules[y][x] = codewords[i>>3]>>(7-i&7)&1 == 1
+				i++
+			}
+		}
+	}
+}
+
+// applyMask inverts the data modules selected by mask pattern mask
+func (q *qrCode) applyMask(mask int) {
+	for y := 0; y < q.size; y++ {
+		for x := 0; x < q.size; x++ {
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if invert && !q.function[y][x] {
+				q.modules[y][x] = !q.modules[y][x]
+			}
+		}
+	}
+}
+
+// penalty scores how hard the symbol is to scan with the four rules of the
+// standard: long runs, 2×2 blocks, finder-like patterns and colour imbalance
+func (q *qrCode) penalty() int {
+	score := 0
+	finderLike := [][]bool{
+		{true, false, true, true, true, false, true, false, false, false, false},
+		{false, false, false, false, true, false, true, true, true, false, true},
+	}
+	for _, transpose := range []bool{false, true} {
+		at := func(a, b int) bool {
+			if transpose {
+				return q.modules[b][a]
+			}
+			return q.modules[a][b]
+		}
+		for a := 0; a < q.size; a++ {
+			run := 1
+			for b := 1; b <= q.size; b++ {
+				if b < q.size && at(a, b) == at(a, b-1) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					score += run - 2
+				}
+				run = 1
+			}
+			for b := 0; b+len(finderLike[0]) <= q.size; b++ {
+				for _, pattern := range finderLike {
+					match := true
+					for k, dark := range pattern {
+						if at(a, b+k) != dark {
+							match = false
+							break
+						}
+					}
+					if match {
+						score += 40
+					}
+				}
+			}
+		}
+	}
+
+	dark := 0
+	for y := 0; y < q.size; y++ {
+		for x := 0; x < q.size; x++ {
+			if q.modules[y][x] {
+				dark++
+			}
+			if x > 0 && y > 0 {
+				c := q.modules[y][x]
+				if q.modules[y-1][x] == c && q.modules[y][x-1] == c && q.modules[y-1][x-1] == c {
+					score += 3
+				}
+			}
+		}
+	}
+	total := q.size * q.size
+	score += 10 * ((abs(dark*20-total*10)+total-1)/total - 1)
+	return score
+}
+
+// Image renders the symbol with scale pixels per module inside the quiet zone
+func (q *qrCode) Image(scale int) *image.Gray {
+	side := (q.size + 2*qrQuietZone) * scale
+	img := image.NewGray(image.Rect(0, 0, side, side))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	for y := 0; y < q.size; y++ {
+		for x := 0; x < q.size; x++ {
+			if !q.modules[y][x] {
+				continue
+			}
+			for py := 0; py < scale; py++ {
+				for px := 0; px < scale; px++ {
+					img.SetGray((x+qrQuietZone)*scale+px, (y+qrQuietZone)*scale+py, color.Gray{})
+				}
+			}
+		}
+	}
+	return img
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// fingerprintQRScale is the pixel size of one QR module in /fingerprint.png
+const fingerprintQRScale = 8
+
+// Render the key fingerprint as a QR code, so Alice and Bob can scan each
+// other's screens to confirm out of band that they hold the same key
+func fingerprintQRHandler(c *gin.Context) {
+	session, ok := sessionFromRequest(c)
+	if !ok {
+		return
+	}
+
+	qr, err := encodeQR([]byte(session.Channel.Fingerprint()))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Failed to encode fingerprint")
+		return
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, qr.Image(fingerprintQRScale)); err != nil {
+		respondError(c, http.StatusInternalServerError, "Failed to encode fingerprint")
+		return
+	}
+	c.Data(http.StatusOK, "image/png", buf.Bytes())
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"math"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Weights of the KeyQuality components; they sum to 100
+const (
+	qualityQBERWeight       = 40
+	qualityLengthWeight     = 30
+	qualityRandomnessWeight = 30
+)
+
+// degenerateKey reports whether a non-empty key has every bit the same,
+// the failure mode of a stuck source or a broken sifting step
+func degenerateKey(key []int) bool {
+	for _, bit := range key {
+		if keyBit(bit) != keyBit(key[0]) {
+			return false
+		}
+	}
+	return len(key) > 0
+}
+
+// KeyQuality scores the final key from 0 to 100 for users who cannot judge
+// QBER and randomness statistics directly. It rewards a QBER well below the
+// abort threshold, a secure length close to what BB84 can ideally yield from
+// the requested bits, and a key that passes the monobit and runs tests.
+func (bb84 *BB84Protocol) KeyQuality() int {
+	if len(bb84.SharedKey) == 0 {
+		return 0
+	}
+
+	qberScore := 0.0
+	if bb84.QBERThreshold > 0 {
+		qberScore = math.Max(0, 1-bb84.qber/bb84.QBERThreshold)
+	}
+
+	// Sifting keeps a fixed share of the bits and sampling discloses a
+	// fraction more. Imported keys have no requested length and score fully here.
+	lengthScore := 1.0
+	if bb84.NumberOfBits > 0 {
+		ideal := float64(bb84.NumberOfBits) * bb84.siftingYield() * (1 - bb84.QBERSampleFraction)
+		lengthScore = math.Min(1, float64(len(bb84.SharedKey))/ideal)
+	}
+
+	randomnessScore := 0.0
+	if monobitPValue(bb84.SharedKey) >= entropySignificance {
+		randomnessScore += 0.5
+	}
+	if runsPValue(bb84.SharedKey) >= entropySignificance {
+		randomnessScore += 0.5
+	}
+
+	score := qualityQBERWeight*qberScore + qualityLengthWeight*lengthScore + qualityRandomnessWeight*randomnessScore
+	return int(math.Round(score))
+}
+
+// byteEntropy returns the Shannon entropy of data's byte distribution in
+// bits per byte, from 0 for a constant key up to 8
+func byteEntropy(data []byte) float64 {
+	if len(data) == 0 {
+		return 0
+	}
+	var counts [256]int
+	for _, b := range data {
+		counts[b]++
+	}
+	h := 0.0
+	for _, n := range counts {
+		if n > 0 {
+			p := float64(n) / float64(len(data))
+			h -= p * math.Log2(p)
+		}
+	}
+	return h
+}
+
+// KeyEntropy returns the Shannon entropy of the key per byte. A short key
+// cannot reach 8 bits per byte, since n bytes hold at most log2(n) bits of
+// distribution entropy, but a key of repeated bytes scores near zero.
+func (sc *SecureChannel) KeyEntropy() float64 {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+	return byteEntropy(sc.keyBytes)
+}
+
+// Report the state of a session's key at a glance
+func statusHandler(c *gin.Context) {
+	session, ok := sessionFromRequest(c)
+	if !ok {
+		return
+	}
+
+	status := gin.H{
+		"sessionId":      session.ID,
+		"imported":       session.Imported,
+		"createdAt":      session.CreatedAt,
+		"keyBits":        len(session.Protocol.SharedKey),
+		"qber":           session.Protocol.qber,
+		"offset":         session.Channel.Offset(),
+		"remainingBytes": session.Channel.Remaining(),
+		"keyQuality":     session.Protocol.KeyQuality(),
+		"encryptionMode": session.Channel.encryptionMode(),
+		"macAlgorithm":   session.Channel.macAlgorithm(),
+		"senders":        session.Channel.SenderStats(),
+	}
+	if session.Channel.Quarantined() {
+		status["quarantined"] = true
+	}
+	if session.Channel.PartitionKeyBySender {
+		status["partitionOffsets"] = session.Channel.PartitionOffsets()
+	}
+	c.JSON(http.StatusOK, status)
+}
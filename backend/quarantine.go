@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ErrSessionQuarantined is returned by every channel operation once an
+// operator has quarantined the session
+var ErrSessionQuarantined = errors.New("session is quarantined")
+
+// quarantine marks the channel unusable and wipes its key material. Later
+// operations fail with ErrSessionQuarantined rather than a key error, so
+// clients can tell an operator action from exhaustion.
+func (sc *SecureChannel) quarantine() {
+	sc.mu.Lock()
+	sc.quarantined = true
+	sc.mu.Unlock()
+	sc.wipe()
+
+	if err := sc.Audit.Record(AuditEvent{Type: AuditSessionQuarantined}); err != nil {
+		log.Printf("audit: %v", err)
+	}
+}
+
+// Quarantined reports whether an operator has quarantined the channel
+func (sc *SecureChannel) Quarantined() bool {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+	return sc.quarantined
+}
+
+// Quarantine disables a session suspected of being attacked. It stays in
+// the session manager so clients get ErrSessionQuarantined until it is
+// initialized again.
+func (s *Session) Quarantine() {
+	if s.Channel != nil {
+		s.Channel.quarantine()
+	}
+	s.wipe()
+	log.Printf("session %q quarantined", s.ID)
+}
+
+// Quarantine a session on suspected attack, zeroing its key
+func quarantineHandler(c *gin.Context) {
+	session, ok := sessionFromRequest(c)
+	if !ok {
+		return
+	}
+
+	session.Quarantine()
+	c.JSON(http.StatusOK, gin.H{"message": "Session quarantined", "sessionId": session.ID})
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	mrand "math/rand"
+	"os"
+	"sync"
+	"time"
+)
+
+// Retry defaults for transient entropy-source failures
+const (
+	DefaultRandRetries = 3
+	randRetryBackoff   = 10 * time.Millisecond
+)
+
+// RandSource supplies the random bits used by the protocol
+type RandSource interface {
+	Bit() (int, error)
+}
+
+// entropyPoolBytes is how much entropy an EntropyPool reads at a time
+const entropyPoolBytes = 4096
+
+// cryptoRandPool is the default entropy source, shared by every protocol run
+var cryptoRandPool = NewEntropyPool(rand.Reader)
+
+// EntropyPool serves random bits from a buffer refilled by large reads from
+// r, so concurrent runs share one read per 32768 bits rather than each making
+// a read per bit. Quality is that of r: every bit is served exactly once and
+// nothing is stretched or reused. It is safe for concurrent use.
+type EntropyPool struct {
+	mu   sync.Mutex
+	r    io.Reader
+	buf  []byte
+	next int // Index of the next unserved bit in buf
+}
+
+// NewEntropyPool creates a pool that draws from r, typically crypto/rand
+func NewEntropyPool(r io.Reader) *EntropyPool {
+	return &EntropyPool{r: r, buf: make([]byte, entropyPoolBytes), next: 8 * entropyPoolBytes}
+}
+
+func (p *EntropyPool) Bit() (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.next == 8*len(p.buf) {
+		if _, err := io.ReadFull(p.r, p.buf); err != nil {
+			return 0, fmt.Errorf("failed to refill entropy pool: %v", err)
+		}
+		p.next = 0
+	}
+	bit := int(p.buf[p.next/8] >> (7 - p.next%8) & 1)
+	p.next++
+	return bit, nil
+}
+
+// randSampleBytes is read from a device source at startup to check it works
+const randSampleBytes = 64
+
+// deviceRandSource draws bits from an entropy device such as a hardware RNG.
+// It is safe for concurrent use.
+type deviceRandSource struct {
+	mu    sync.Mutex
+	r     *bufio.Reader
+	cur   byte
+	avail int // Unused bits left in cur
+}
+
+// OpenDeviceRandSource opens an entropy device file and checks that it can
+// be read and does not return constant output
+func OpenDeviceRandSource(path string) (*deviceRandSource, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	src := &deviceRandSource{r: bufio.NewReader(f)}
+	sample := make([]byte, randSampleBytes)
+	if _, err := io.ReadFull(src.r, sample); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to read from %s: %v", path, err)
+	}
+	if bytes.Count(sample, sample[:1]) == len(sample) {
+		f.Close()
+		return nil, fmt.Errorf("%s returned constant output", path)
+	}
+	return src, nil
+}
+
+func (d *deviceRandSource) Bit() (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.avail == 0 {
+		b, err := d.r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		d.cur, d.avail = b, 8
+	}
+	d.avail--
+	return int(d.cur>>d.avail) & 1, nil
+}
+
+// Read lets the startup entropy self-test sample the device directly
+func (d *deviceRandSource) Read(p []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.r.Read(p)
+}
+
+// retryRandSource retries a failing source with exponential backoff before
+// giving up, to ride out transient entropy-source hiccups
+type retryRandSource struct {
+	src     RandSource
+	retries int
+}
+
+// NewRetryRandSource wraps src so each bit is attempted up to retries+1 times
+func NewRetryRandSource(src RandSource, retries int) RandSource {
+	if retries <= 0 {
+		return src
+	}
+	return &retryRandSource{src: src, retries: retries}
+}
+
+func (r *retryRandSource) Bit() (int, error) {
+	backoff := randRetryBackoff
+	for attempt := 0; ; attempt++ {
+		bit, err := r.src.Bit()
+		if err == nil || attempt == r.retries {
+			return bit, err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// seededRandSource produces a reproducible bit sequence. It is NOT suitable
+// for key generation and exists only for test vectors and simulations.
+type seededRandSource struct {
+	rng *mrand.Rand
+}
+
+// NewSeededRandSource creates a deterministic RandSource from seed
+func NewSeededRandSource(seed int64) RandSource {
+	return &seededRandSource{rng: mrand.New(mrand.NewSource(seed))}
+}
+
+func (s *seededRandSource) Bit() (int, error) {
+	return s.rng.Intn(2), nil
+}
+
+// bernoulli returns true with probability p. It compares a uniform random
+// number against p one binary digit at a time, using two bits on average.
+func bernoulli(src RandSource, p float64) (bool, error) {
+	if p <= 0 {
+		return false, nil
+	}
+	if p >= 1 {
+		return true, nil
+	}
+	for i := 0; i < 53; i++ {
+		p *= 2
+		digit := 0
+		if p >= 1 {
+			digit = 1
+			p--
+		}
+		bit, err := src.Bit()
+		if err != nil {
+			return false, err
+		}
+		if bit != digit {
+			return bit < digit, nil
+		}
+	}
+	return false, nil
+}
+
+// contextCheckInterval is how many bits a contextRandSource draws between
+// checks of its context
+const contextCheckInterval = 1 << 12
+
+// contextRandSource fails once its context is done, so long generation and
+// transmission loops stop promptly when a run's time budget runs out
+type contextRandSource struct {
+	ctx   context.Context
+	src   RandSource
+	drawn int
+}
+
+func newContextRandSource(ctx context.Context, src RandSource) *contextRandSource {
+	return &contextRandSource{ctx: ctx, src: src}
+}
+
+func (s *contextRandSource) Bit() (int, error) {
+	s.drawn++
+	if s.drawn%contextCheckInterval == 0 {
+		if err := s.ctx.Err(); err != nil {
+			return 0, err
+		}
+	}
+	return s.src.Bit()
+}
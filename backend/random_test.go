@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"math/big"
+	mrand "math/rand"
+	"sync/atomic"
+	"testing"
+)
+
+// countingReader counts the reads made of r
+type countingReader struct {
+	r     io.Reader
+	reads atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	c.reads.Add(1)
+	return c.r.Read(p)
+}
+
+// perBitSource is the source EntropyPool replaced: one crypto/rand read
+// for every bit
+type perBitSource struct{ r io.Reader }
+
+func (s perBitSource) Bit() (int, error) {
+	num, err := rand.Int(s.r, big.NewInt(2))
+	if err != nil {
+		return 0, err
+	}
+	return int(num.Int64()), nil
+}
+
+// BenchmarkEntropySource draws bits from many goroutines at once, as
+// concurrent protocol runs do, and reports reads of crypto/rand per bit
+func BenchmarkEntropySource(b *testing.B) {
+	for _, tc := range []struct {
+		name string
+		src  func(io.Reader) RandSource
+	}{
+		{"per-bit", func(r io.Reader) RandSource { return perBitSource{r} }},
+		{"pool", func(r io.Reader) RandSource { return NewEntropyPool(r) }},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			reader := &countingReader{r: rand.Reader}
+			src := tc.src(reader)
+			b.SetParallelism(8)
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, err := src.Bit(); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+			b.ReportMetric(float64(reader.reads.Load())/float64(b.N), "reads/bit")
+		})
+	}
+}
+
+// TestEntropyPoolServesEveryBitOnce checks that the pool hands out its
+// reader's bits in order, most significant first, reading a whole buffer
+// at a time
+func TestEntropyPoolServesEveryBitOnce(t *testing.T) {
+	data := make([]byte, 3*entropyPoolBytes)
+	mrand.New(mrand.NewSource(13)).Read(data)
+	reader := &countingReader{r: bytes.NewReader(data)}
+	pool := NewEntropyPool(reader)
+
+	for i, want := range bytesToKey(data) {
+		bit, err := pool.Bit()
+		if err != nil {
+			t.Fatalf("bit %d: %v", i, err)
+		}
+		if bit != want {
+			t.Fatalf("bit %d = %d, want %d", i, bit, want)
+		}
+	}
+	if reads := reader.reads.Load(); reads != 3 {
+		t.Errorf("%d reads for three buffers of bits", reads)
+	}
+	if _, err := pool.Bit(); err == nil {
+		t.Error("exhausted reader did not fail the pool")
+	}
+}
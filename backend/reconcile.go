@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// DefaultMaxReconciliationPasses is the number of CASCADE passes run when
+// ProtocolOptions leaves MaxReconciliationPasses at zero
+const DefaultMaxReconciliationPasses = 4
+
+// minCascadeQBER floors the QBER used to size the first pass's blocks, so a
+// key whose sample happened to contain no errors is still checked in blocks
+// small enough to catch an even number of errors
+const minCascadeQBER = 0.01
+
+// ErrReconciliationFailed is returned by ReconcileKeys when Bob's key still
+// differs from Alice's after the last pass
+var ErrReconciliationFailed = errors.New("reconciliation failed")
+
+// cascadePass is one public permutation of the key, split into blocks whose
+// parities Alice and Bob compare
+type cascadePass struct {
+	order     []int // Key positions in the order of this pass
+	position  []int // Index of each key position within order
+	blockSize int
+}
+
+func (p *cascadePass) blocks() int {
+	return (len(p.order) + p.blockSize - 1) / p.blockSize
+}
+
+// block returns the key positions in block b
+func (p *cascadePass) block(b int) []int {
+	start := b * p.blockSize
+	return p.order[start:min(start+p.blockSize, len(p.order))]
+}
+
+// blockOf returns the block of this pass that holds key position i
+func (p *cascadePass) blockOf(i int) int {
+	return p.position[i] / p.blockSize
+}
+
+// parity returns the XOR of key over positions
+func parity(key, positions []int) int {
+	v := 0
+	for _, i := range positions {
+		v ^= key[i]
+	}
+	return v
+}
+
+// randomIndex draws a uniform integer in [0, n) by rejection sampling
+func randomIndex(src RandSource, n int) (int, error) {
+	width := 0
+	for 1<<width < n {
+		width++
+	}
+	for {
+		v := 0
+		for i := 0; i < width; i++ {
+			bit, err := src.Bit()
+			if err != nil {
+				return 0, err
+			}
+			v = v<<1 | bit
+		}
+		if v < n {
+			return v, nil
+		}
+	}
+}
+
+// newCascadePass builds pass number pass over n bits. The first pass keeps
+// the key order; later ones shuffle it with a permutation drawn from src,
+// which, like the extractor seed, may be public.
+func newCascadePass(src RandSource, pass, n, blockSize int) (*cascadePass, error) {
+	p := &cascadePass{order: make([]int, n), position: make([]int, n), blockSize: blockSize}
+	for i := range p.order {
+		p.order[i] = i
+	}
+	if pass > 0 {
+		for i := n - 1; i > 0; i-- {
+			j, err := randomIndex(src, i+1)
+			if err != nil {
+				return nil, fmt.Errorf("failed to generate reconciliation permutation: %v", err)
+			}
+			p.order[i], p.order[j] = p.order[j], p.order[i]
+		}
+	}
+	for idx, i := range p.order {
+		p.position[i] = idx
+	}
+	return p, nil
+}
+
+// bisect locates one differing position in a block of odd relative parity,
+// disclosing the parity of the left half at each step
+func bisect(alice, bob, positions []int) int {
+	for len(positions) > 1 {
+		half := positions[:len(positions)/2]
+		if parity(alice, half) != parity(bob, half) {
+			positions = half
+		} else {
+			positions = positions[len(half):]
+		}
+	}
+	return positions[0]
+}
+
+// ReconcileKeys corrects Bob's copy of the remaining sifted key against
+// Alice's with the CASCADE protocol. First-pass blocks are sized from qber
+// and each later pass doubles them. Every corrected bit is traced back
+// through the earlier passes, whose blocks holding it now disagree. If the
+// keys still differ after MaxReconciliationPasses it returns
+// ErrReconciliationFailed rather than let a corrupt key reach the channel.
+// It returns ctx's error as soon as it notices ctx is done.
+func (bb84 *BB84Protocol) ReconcileKeys(ctx context.Context, qber float64, report *DistillationReport) error {
+	alice := bb84.SharedKey
+	n := len(alice)
+	bob := make([]int, n)
+	copy(bob, bb84.bobSiftedKey[bb84.sampleSize:])
+
+	maxPasses := bb84.MaxReconciliationPasses
+	if maxPasses <= 0 {
+		maxPasses = DefaultMaxReconciliationPasses
+	}
+
+	blockSize := int(math.Ceil(0.73 / math.Max(qber, minCascadeQBER)))
+	passes := make([]*cascadePass, 0, maxPasses)
+	for len(passes) < maxPasses && n > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		pass, err := newCascadePass(bb84.Rand, len(passes), n, min(blockSize, n))
+		if err != nil {
+			return err
+		}
+		passes = append(passes, pass)
+		blockSize *= 2
+
+		type pending struct{ pass, block int }
+		var queue []pending
+		for b := 0; b < pass.blocks(); b++ {
+			queue = append(queue, pending{len(passes) - 1, b})
+		}
+		for checked := 1; len(queue) > 0; checked++ {
+			if checked%contextCheckInterval == 0 {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+			}
+			next := queue[0]
+			queue = queue[1:]
+			positions := passes[next.pass].block(next.block)
+			if parity(alice, positions) == parity(bob, positions) {
+				continue
+			}
+			i := bisect(alice, bob, positions)
+			bob[i] ^= 1
+			for p, other := range passes {
+				if p != next.pass {
+					queue = append(queue, pending{p, other.blockOf(i)})
+				}
+			}
+		}
+	}
+	report.ReconciliationPasses = len(passes)
+
+	// Stands in for the hash comparison that confirms the keys agree
+	for i := range alice {
+		if alice[i] != bob[i] {
+			report.ResidualErrors++
+		}
+	}
+	if report.ResidualErrors > 0 {
+		return fmt.Errorf("%w: %d residual errors after %d passes", ErrReconciliationFailed, report.ResidualErrors, len(passes))
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RedactionPolicy selects what happens to sensitive fields of JSON responses
+// outside debug mode
+type RedactionPolicy int
+
+const (
+	RedactStrip RedactionPolicy = iota // Remove the field; the default
+	RedactMask                         // Keep the field with redactedValue as its value
+	RedactOff                          // Leave responses untouched
+)
+
+// redactedValue replaces a masked field's value
+const redactedValue = "[redacted]"
+
+// DefaultRedactFields are the response fields redacted when
+// QCHAT_REDACT_FIELDS is unset: key material, per-qubit traces and Bob's
+// raw measurements
+var DefaultRedactFields = []string{"sharedKey", "trace", "qubits", "measurements"}
+
+// parseRedactionPolicy parses QCHAT_REDACTION, defaulting to strip
+func parseRedactionPolicy(s string) (RedactionPolicy, error) {
+	switch s {
+	case "", "strip":
+		return RedactStrip, nil
+	case "mask":
+		return RedactMask, nil
+	case "off":
+		return RedactOff, nil
+	default:
+		return 0, fmt.Errorf("unknown QCHAT_REDACTION %q", s)
+	}
+}
+
+// redactKeys applies policy to every object field named in fields, at any
+// depth of a decoded JSON value
+func redactKeys(v any, fields map[string]bool, policy RedactionPolicy) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, val := range v {
+			switch {
+			case !fields[k]:
+				v[k] = redactKeys(val, fields, policy)
+			case policy == RedactMask:
+				v[k] = redactedValue
+			default:
+				delete(v, k)
+			}
+		}
+		return v
+	case []any:
+		for i := range v {
+			v[i] = redactKeys(v[i], fields, policy)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// redactResponses removes or masks the named fields from every JSON
+// response unless the server runs in debug mode, as a last line of defence
+// against a handler leaking key material. Non-JSON downloads such as
+// /key.pem and /keyfile are the key by design and pass through. It must be
+// registered after jsonNaming so it sees the internal camelCase names, and
+// a signed response must not carry a redacted field, as the signature
+// covers the unredacted body.
+func redactResponses(policy RedactionPolicy, fields []string) gin.HandlerFunc {
+	names := make(map[string]bool, len(fields))
+	for _, f := range fields {
+		names[f] = true
+	}
+	return func(c *gin.Context) {
+		if policy == RedactOff || len(names) == 0 || mode.DebugEnabled() || isWebSocketUpgrade(c.Request) || isEventStream(c.Request) {
+			c.Next()
+			return
+		}
+
+		original := c.Writer
+		buffered := &bufferedResponseWriter{ResponseWriter: original, status: http.StatusOK}
+		c.Writer = buffered
+		c.Next()
+		c.Writer = original
+
+		body := buffered.buf.Bytes()
+		contentType := original.Header().Get("Content-Type")
+		if strings.HasPrefix(contentType, "application/json") || strings.HasPrefix(contentType, problemContentType) {
+			if redacted, err := redactJSON(body, names, policy); err == nil {
+				body = redacted
+			}
+		}
+		original.WriteHeader(buffered.status)
+		original.Write(body)
+	}
+}
+
+// redactJSON applies policy to a JSON document, preserving numbers exactly.
+// Documents mentioning none of the fields are returned as they are.
+func redactJSON(data []byte, fields map[string]bool, policy RedactionPolicy) ([]byte, error) {
+	mentioned := false
+	for f := range fields {
+		if bytes.Contains(data, []byte(`"`+f+`"`)) {
+			mentioned = true
+			break
+		}
+	}
+	if !mentioned {
+		return data, nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(redactKeys(v, fields, policy))
+}
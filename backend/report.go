@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// reportWriter renders a run report as Markdown or plain text
+type reportWriter struct {
+	buf      bytes.Buffer
+	markdown bool
+}
+
+func (w *reportWriter) heading(title string) {
+	if w.markdown {
+		fmt.Fprintf(&w.buf, "## %s\n\n", title)
+		return
+	}
+	fmt.Fprintf(&w.buf, "%s\n%s\n\n", title, bytes.Repeat([]byte("-"), len(title)))
+}
+
+// table writes rows of label/value pairs
+func (w *reportWriter) table(rows [][2]string) {
+	if w.markdown {
+		w.buf.WriteString("| Field | Value |\n|---|---|\n")
+		for _, row := range rows {
+			fmt.Fprintf(&w.buf, "| %s | %s |\n", row[0], row[1])
+		}
+	} else {
+		for _, row := range rows {
+			fmt.Fprintf(&w.buf, "%-34s %s\n", row[0]+":", row[1])
+		}
+	}
+	w.buf.WriteString("\n")
+}
+
+func (w *reportWriter) paragraph(text string) {
+	w.buf.WriteString(text + "\n\n")
+}
+
+// percent formats a ratio, guarding against an empty denominator
+func percent(n, d int) string {
+	if d == 0 {
+		return "n/a"
+	}
+	return fmt.Sprintf("%.1f%%", 100*float64(n)/float64(d))
+}
+
+// BuildReport summarizes a session's protocol run for lab write-ups
+func BuildReport(s *Session, markdown bool) []byte {
+	w := &reportWriter{markdown: markdown}
+	p := s.Protocol
+	d := s.Result.Distillation
+
+	title := fmt.Sprintf("QKD run report: session %s", s.ID)
+	if markdown {
+		fmt.Fprintf(&w.buf, "# %s\n\n", title)
+	} else {
+		fmt.Fprintf(&w.buf, "%s\n%s\n\n", title, bytes.Repeat([]byte("="), len(title)))
+	}
+	w.paragraph("Generated " + time.Now().UTC().Format(time.RFC3339) + ".")
+	if s.Imported {
+		w.paragraph("This session uses an imported preshared key; no protocol run was recorded.")
+		return w.buf.Bytes()
+	}
+
+	w.heading("Parameters")
+	w.table([][2]string{
+		{"Protocol", string(p.Name())},
+		{"Requested bits", fmt.Sprint(p.NumberOfBits)},
+		{"QBER sample fraction", fmt.Sprint(p.QBERSampleFraction)},
+		{"QBER abort threshold", fmt.Sprint(p.QBERThreshold)},
+		{"Extractor", p.Extractor.String()},
+		{"Preparation flaw probability", fmt.Sprint(p.PrepFlawProbability)},
+		{"Injected error rate", fmt.Sprint(p.InjectedErrorRate)},
+		{"Intercept-resend attacker", fmt.Sprint(p.InterceptResend)},
+	})
+
+	w.heading("Phase timings")
+	var timingRows [][2]string
+	for _, t := range p.StageTimings() {
+		timingRows = append(timingRows, [2]string{string(t.Stage), t.Duration.String()})
+	}
+	if len(timingRows) == 0 {
+		w.paragraph("No timings were recorded for this run.")
+	} else {
+		w.table(timingRows)
+	}
+
+	w.heading("Error estimation")
+	w.table([][2]string{
+		{"QBER", fmt.Sprintf("%.4f", s.Result.Qber)},
+		{"Sifted bits", fmt.Sprint(d.SiftedBits)},
+		{"Sifting efficiency", fmt.Sprintf("%s (ideal %.0f%%)", percent(d.SiftedBits, d.RawBits), 100*p.siftingYield())},
+	})
+
+	w.heading("Distillation")
+	w.table([][2]string{
+		{"Raw bits", fmt.Sprint(d.RawBits)},
+		{"Discarded by sifting", fmt.Sprint(d.RawBits - d.SiftedBits)},
+		{"Disclosed for QBER", fmt.Sprint(d.SampledBits)},
+		{"Reconciliation passes", fmt.Sprintf("%d (%d residual errors)", d.ReconciliationPasses, d.ResidualErrors)},
+		{"Reconciliation leakage", fmt.Sprint(d.ReconciliationLeakBits)},
+		{"Removed by privacy amplification", fmt.Sprint(d.PrivacyAmplificationBits)},
+		{"Secure bits", fmt.Sprintf("%d (%s of raw)", d.SecureBits, percent(d.SecureBits, d.RawBits))},
+	})
+
+	w.heading("Eavesdropping detection")
+	// Runs that timed out or sifted too few bits say nothing about an
+	// eavesdropper, so only aborts for a high QBER are counted
+	aborted := 0
+	history := s.QBERHistory()
+	for _, sample := range history {
+		if sample.Aborted && sample.Reason == ReasonEavesdropper {
+			aborted++
+		}
+	}
+	w.paragraph(fmt.Sprintf("The QBER of %.4f is below the abort threshold of %.4f, so no eavesdropping was detected in this run.",
+		s.Result.Qber, p.QBERThreshold))
+	if aborted > 0 {
+		w.paragraph(fmt.Sprintf("%d of %d runs in this session were aborted for exceeding the threshold.", aborted, len(history)))
+	}
+	return w.buf.Bytes()
+}
+
+// Download a report of the session's protocol run as Markdown or plain text
+func reportHandler(c *gin.Context) {
+	contentType := "text/markdown; charset=utf-8"
+	markdown := true
+	switch c.DefaultQuery("format", "md") {
+	case "md":
+	case "txt":
+		contentType = "text/plain; charset=utf-8"
+		markdown = false
+	default:
+		respondError(c, http.StatusBadRequest, "format must be md or txt")
+		return
+	}
+
+	session, ok := sessionFromRequest(c)
+	if !ok {
+		return
+	}
+
+	c.Data(http.StatusOK, contentType, BuildReport(session, markdown))
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// roomMarker prefixes the room name in the MAC input of messages sent to a
+// room, for the same reason as compressedMarker
+const roomMarker = 0xfd
+
+// ErrInvalidRoom is returned for a room name that cannot be stored as is
+var ErrInvalidRoom = errors.New("invalid room")
+
+// normalizeRoom validates a room name with the same rules as sender names
+func normalizeRoom(room string) (string, error) {
+	return normalizeName(room, maxSenderLength, ErrInvalidRoom)
+}
+
+// EncryptInRoom encrypts a message for a room at the current key offset and
+// advances it. Rooms only group messages: every room draws on the same key
+// and offsets, and the room name is covered by the MAC so a message cannot
+// be moved to another room unnoticed.
+func (sc *SecureChannel) EncryptInRoom(plaintext, sender, room string) (*Message, error) {
+	defer sc.padTiming(time.Now())
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.encryptNext(plaintext, sender, room, 0)
+}
+
+// RoomHistory returns the stored messages of one room, sorted by sequence
+// number. The empty room holds messages sent without one.
+func (sc *SecureChannel) RoomHistory(room string) []Message {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+
+	msgs := make([]Message, 0)
+	for i := 0; i < sc.messageCount(); i++ {
+		if msg := sc.messageAt(i); msg.Room == room {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs
+}
+
+// RoomPage is Page restricted to the messages of one room. nextCursor is 0
+// once no earlier message of the room remains.
+func (sc *SecureChannel) RoomPage(room string, before, limit int) (page []Message, nextCursor int) {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+
+	page = make([]Message, 0, limit)
+	i := sc.messageCount()
+	if before > 0 {
+		i = sort.Search(i, func(i int) bool { return sc.messageSeq(i) >= before })
+	}
+	for i--; i >= 0; i-- {
+		msg := sc.messageAt(i)
+		if msg.Room != room {
+			continue
+		}
+		if len(page) == limit {
+			nextCursor = page[len(page)-1].Seq
+			break
+		}
+		page = append(page, msg)
+	}
+
+	for l, r := 0, len(page)-1; l < r; l, r = l+1, r-1 {
+		page[l], page[r] = page[r], page[l]
+	}
+	return page, nextCursor
+}
@@ -0,0 +1,68 @@
+package main
+
+import "fmt"
+
+// SARG04Protocol runs SARG04 on BB84 hardware. Alice sends the same four
+// states, but the key bit is the basis of the state rather than its value,
+// and instead of announcing her basis she announces a pair of
+// non-orthogonal states, one from each basis, that contains the state sent.
+// Bob keeps a qubit only when his outcome is orthogonal to one state of the
+// pair, which rules it out. This makes photon-number-splitting attacks on
+// multi-photon pulses less effective than against BB84, at the cost of
+// keeping about a quarter of the qubits instead of half.
+type SARG04Protocol struct {
+	*BB84Protocol
+}
+
+// NewSARG04Protocol creates a new instance of the SARG04 protocol
+func NewSARG04Protocol(bits int) *SARG04Protocol {
+	bb84 := NewBB84Protocol(bits)
+	bb84.sifter = &sarg04Sifter{}
+	return &SARG04Protocol{BB84Protocol: bb84}
+}
+
+// sarg04Sifter applies the SARG04 sifting rule
+type sarg04Sifter struct {
+	partners []int // Value of the announced state in the basis Alice did not use
+}
+
+func (*sarg04Sifter) name() ProtocolName {
+	return ProtocolSARG04
+}
+
+// sift announces a state pair per qubit and keeps the conclusive results.
+// The pair holds the sent state and a random state from the other basis;
+// Bob infers the sent basis when his outcome excludes one of them.
+func (s *sarg04Sifter) sift(bb84 *BB84Protocol) error {
+	// Pairs announced for an earlier batch stand; only new qubits get one
+	for len(s.partners) < bb84.NumberOfBits {
+		bit, err := bb84.Rand.Bit()
+		if err != nil {
+			return fmt.Errorf("failed to choose announced state: %v", err)
+		}
+		s.partners = append(s.partners, bit)
+	}
+
+	bb84.SharedKey = make([]int, 0)
+	bb84.siftedIndices = make([]int, 0)
+	bb84.bobSiftedKey = make([]int, 0)
+	for i := 0; i < bb84.NumberOfBits; i++ {
+		// The announced pair as (Z value, X value)
+		pair := [2]int{bb84.Alice.bits[i], s.partners[i]}
+		if bb84.Alice.bases[i] == XBasis {
+			pair = [2]int{s.partners[i], bb84.Alice.bits[i]}
+		}
+
+		// An outcome differing from the pair state in Bob's basis excludes
+		// that state, so the other basis was sent
+		bobBasis := bb84.Bob.bases[i]
+		if !bb84.detected(i) || bb84.Bob.measuredBits[i] == pair[bobBasis] {
+			continue
+		}
+		bb84.SharedKey = append(bb84.SharedKey, int(bb84.Alice.bases[i]))
+		bb84.siftedIndices = append(bb84.siftedIndices, i)
+		bb84.bobSiftedKey = append(bb84.bobSiftedKey, int(1-bobBasis))
+	}
+	bb84.siftedKey = bb84.SharedKey
+	return nil
+}
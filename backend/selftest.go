@@ -0,0 +1,199 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// defaultEntropySampleBits is the size of the startup RNG sample
+	defaultEntropySampleBits = 1 << 16
+
+	// entropySignificance is the p-value below which a test is considered failed
+	entropySignificance = 0.01
+
+	// selfTestCanary is the plaintext round-tripped by SelfTest, kept short
+	// because every run consumes its length in key bytes
+	selfTestCanary = "qchat-canary"
+
+	// selfTestSender is the sender of the canary and of its meta event
+	selfTestSender = "self-test"
+)
+
+// ChannelSelfTest holds the outcome of a canary round trip through a channel
+type ChannelSelfTest struct {
+	Passed    bool   `json:"passed"`
+	Offset    int    `json:"offset"`
+	KeyBytes  int    `json:"keyBytes"` // Key consumed by the canary
+	MacValid  bool   `json:"macValid"`
+	RoundTrip bool   `json:"roundTrip"`
+	Error     string `json:"error,omitempty"`
+}
+
+// SelfTest encrypts a fixed canary at the next key offset, as a message
+// would be, then authenticates and decrypts it and compares the result.
+// The canary consumes its length in key bytes so no pad byte is ever reused,
+// but only a meta event is stored in the history. It is not a message: it
+// is exempt from MaxPlaintextBytes and counted against no sender. A key
+// partitioned by sender has no range to spare and returns ErrKeyPartitioned.
+func (sc *SecureChannel) SelfTest() (ChannelSelfTest, error) {
+	sc.mu.Lock()
+	if err := sc.selfTestAllowed(); err != nil {
+		sc.mu.Unlock()
+		return ChannelSelfTest{}, err
+	}
+	msg, n, err := sc.sealPlaintext(selfTestCanary, selfTestSender, "")
+	if err != nil {
+		sc.mu.Unlock()
+		return ChannelSelfTest{}, err
+	}
+	sc.offset += n
+	if err := sc.Audit.Record(AuditEvent{Type: AuditOffsetAdvanced, Bytes: n, Offset: msg.Offset}); err != nil {
+		log.Printf("audit: %v", err)
+	}
+	result := ChannelSelfTest{Offset: msg.Offset, KeyBytes: n}
+	sc.appendMessage(&Message{Kind: KindMeta, Sender: selfTestSender, Type: "self-test", Timestamp: time.Now().UTC()})
+	sc.mu.Unlock()
+
+	if err := sc.VerifyMessage(msg); err != nil {
+		result.Error = err.Error()
+		return result, nil
+	}
+	result.MacValid = true
+
+	plaintext, err := sc.DecryptMessage(msg)
+	if err != nil {
+		result.Error = err.Error()
+		return result, nil
+	}
+	result.RoundTrip = plaintext == selfTestCanary
+	result.Passed = result.RoundTrip
+	if !result.RoundTrip {
+		result.Error = "decrypted canary does not match"
+	}
+	return result, nil
+}
+
+// selfTestAllowed applies the checks sealNext makes before encrypting that
+// still apply to the canary. The caller must hold sc.mu.
+func (sc *SecureChannel) selfTestAllowed() error {
+	switch {
+	case sc.quarantined:
+		return ErrSessionQuarantined
+	case sc.RequireQBERCheck && !sc.checked:
+		return ErrQBERNotChecked
+	case sc.PartitionKeyBySender:
+		return ErrKeyPartitioned
+	}
+	return nil
+}
+
+// EntropySelfTest holds the outcome of the startup randomness checks
+type EntropySelfTest struct {
+	Ran           bool    `json:"ran"`
+	SampleBits    int     `json:"sampleBits"`
+	MonobitPValue float64 `json:"monobitPValue"`
+	RunsPValue    float64 `json:"runsPValue"`
+	Passed        bool    `json:"passed"`
+	Error         string  `json:"error,omitempty"`
+}
+
+// RunEntropySelfTest draws sampleBits from r and applies the NIST SP 800-22
+// frequency (monobit) and runs tests. It only detects gross bias; passing
+// does not prove the source is cryptographically secure.
+func RunEntropySelfTest(r io.Reader, sampleBits int) EntropySelfTest {
+	result := EntropySelfTest{Ran: true, SampleBits: sampleBits}
+
+	buf := make([]byte, (sampleBits+7)/8)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		result.Error = fmt.Sprintf("failed to read entropy sample: %v", err)
+		return result
+	}
+	bits := bytesToKey(buf)[:sampleBits]
+
+	result.MonobitPValue = monobitPValue(bits)
+	result.RunsPValue = runsPValue(bits)
+	result.Passed = result.MonobitPValue >= entropySignificance && result.RunsPValue >= entropySignificance
+	return result
+}
+
+// monobitPValue checks that ones and zeros occur in roughly equal numbers
+func monobitPValue(bits []int) float64 {
+	if len(bits) == 0 {
+		return 0
+	}
+	sum := 0
+	for _, b := range bits {
+		sum += 2*b - 1
+	}
+	sObs := math.Abs(float64(sum)) / math.Sqrt(float64(len(bits)))
+	return math.Erfc(sObs / math.Sqrt2)
+}
+
+// runsPValue checks that runs of identical bits have the expected lengths
+func runsPValue(bits []int) float64 {
+	n := float64(len(bits))
+	if n == 0 {
+		return 0
+	}
+	ones := 0
+	for _, b := range bits {
+		ones += b
+	}
+	pi := float64(ones) / n
+	if math.Abs(pi-0.5) >= 2/math.Sqrt(n) {
+		return 0 // frequency prerequisite failed
+	}
+
+	runs := 1
+	for i := 1; i < len(bits); i++ {
+		if bits[i] != bits[i-1] {
+			runs++
+		}
+	}
+	num := math.Abs(float64(runs) - 2*n*pi*(1-pi))
+	den := 2 * math.Sqrt(2*n) * pi * (1 - pi)
+	return math.Erfc(num / den)
+}
+
+// Report server health, including the startup entropy self-test
+func healthHandler(c *gin.Context) {
+	status := "ok"
+	if entropySelfTest.Ran && !entropySelfTest.Passed {
+		status = "degraded"
+	}
+	c.JSON(http.StatusOK, gin.H{"status": status, "entropy": entropySelfTest})
+}
+
+// Round-trip a canary through a session's channel, as a readiness probe
+func selfTestHandler(c *gin.Context) {
+	session, ok := sessionFromRequest(c)
+	if !ok {
+		return
+	}
+
+	result, err := session.Channel.SelfTest()
+	switch {
+	case errors.Is(err, ErrSessionQuarantined):
+		respondError(c, http.StatusLocked, "Session is quarantined")
+	case errors.Is(err, ErrQBERNotChecked):
+		respondError(c, http.StatusConflict, "Key was never checked for eavesdropping")
+	case errors.Is(err, ErrKeyPartitioned):
+		respondError(c, http.StatusConflict, "Key is partitioned by sender")
+	case errors.Is(err, ErrKeyExhausted):
+		respondError(c, http.StatusConflict, "Not enough key left for the canary")
+	case err != nil:
+		respondError(c, http.StatusInternalServerError, "Failed to encrypt canary")
+	case !result.Passed:
+		c.JSON(http.StatusServiceUnavailable, result)
+	default:
+		c.JSON(http.StatusOK, result)
+	}
+}
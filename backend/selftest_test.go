@@ -0,0 +1,31 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// TestSelfTestIsNotAMessage checks that the canary consumes key but is
+// exempt from the plaintext limit and from per-sender accounting
+func TestSelfTestIsNotAMessage(t *testing.T) {
+	sc := randomChannel(t, rand.New(rand.NewSource(16)), 256)
+	sc.MaxPlaintextBytes = len(selfTestCanary) - 1
+	remaining := sc.Remaining()
+
+	result, err := sc.SelfTest()
+	if err != nil || !result.Passed {
+		t.Fatalf("SelfTest = %+v, %v", result, err)
+	}
+	if used := remaining - sc.Remaining(); used != len(selfTestCanary) || result.KeyBytes != used {
+		t.Errorf("canary used %d key bytes and reported %d, want %d", used, result.KeyBytes, len(selfTestCanary))
+	}
+	if stats := sc.SenderStats(); len(stats) != 0 {
+		t.Errorf("canary was counted as a sender: %v", stats)
+	}
+	if _, err := sc.EncryptMessage("hi", "alice"); err != nil {
+		t.Fatalf("EncryptMessage after the self-test: %v", err)
+	}
+	if msgs := sc.History(); len(msgs) != 2 || msgs[0].Kind != KindMeta {
+		t.Errorf("history after the self-test is %+v", msgs)
+	}
+}
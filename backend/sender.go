@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// DefaultMaxSenderLength is the longest sender name, in characters, accepted
+// when QCHAT_MAX_SENDER_LENGTH is unset
+const DefaultMaxSenderLength = 64
+
+// ErrInvalidSender is returned for a sender name that cannot be stored as is
+var ErrInvalidSender = errors.New("invalid sender")
+
+// normalizeSender validates a sender name and returns its canonical form:
+// NFC-normalized with surrounding whitespace removed, so names that look
+// alike compare, partition and authenticate alike. It rejects invalid
+// UTF-8, names longer than maxLength characters, and control or format
+// characters, which could forge log lines or reorder displayed text. An
+// empty name stays empty.
+func normalizeSender(sender string, maxLength int) (string, error) {
+	return normalizeName(sender, maxLength, ErrInvalidSender)
+}
+
+// normalizeName applies the rules of normalizeSender to any user-chosen
+// name, wrapping invalid for a rejected one
+func normalizeName(name string, maxLength int, invalid error) (string, error) {
+	if !utf8.ValidString(name) {
+		return "", fmt.Errorf("%w: not valid UTF-8", invalid)
+	}
+	name = strings.TrimSpace(norm.NFC.String(name))
+	if n := utf8.RuneCountInString(name); n > maxLength {
+		return "", fmt.Errorf("%w: %d characters, limit is %d", invalid, n, maxLength)
+	}
+	for _, r := range name {
+		if unicode.IsControl(r) || unicode.Is(unicode.Cf, r) {
+			return "", fmt.Errorf("%w: contains control character %U", invalid, r)
+		}
+	}
+	return name, nil
+}
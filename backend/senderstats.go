@@ -0,0 +1,36 @@
+package main
+
+// SenderStats counts what one sender has encrypted on a channel
+type SenderStats struct {
+	Messages int `json:"messages"`
+	KeyBytes int `json:"keyBytes"` // One-time pad bytes consumed
+}
+
+// recordEncryption counts a message from sender that consumed n key bytes.
+// The caller must hold sc.mu.
+func (sc *SecureChannel) recordEncryption(sender string, n int) {
+	if sc.senders == nil {
+		sc.senders = make(map[string]*SenderStats)
+	}
+	stats, ok := sc.senders[sender]
+	if !ok {
+		stats = &SenderStats{}
+		sc.senders[sender] = stats
+	}
+	stats.Messages++
+	stats.KeyBytes += n
+}
+
+// SenderStats returns each sender's message count and key consumption, to
+// show which participant is exhausting the key. Ranges claimed with
+// ReserveKey have no sender and are not included.
+func (sc *SecureChannel) SenderStats() map[string]SenderStats {
+	sc.mu.RLock()
+	defer sc.mu.RUnlock()
+
+	stats := make(map[string]SenderStats, len(sc.senders))
+	for sender, s := range sc.senders {
+		stats[sender] = *s
+	}
+	return stats
+}
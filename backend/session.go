@@ -0,0 +1,193 @@
+package main
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// defaultSessionID is used when a request does not name a session
+const defaultSessionID = "default"
+
+// Errors returned by SessionManager lookups
+var (
+	ErrSessionNotFound = errors.New("session not found")
+	ErrSessionEvicted  = errors.New("session was evicted")
+)
+
+// QBERSample is the error rate measured by one protocol run
+type QBERSample struct {
+	Time    time.Time   `json:"time"`
+	Qber    float64     `json:"qber"`
+	Aborted bool        `json:"aborted"`
+	Reason  AbortReason `json:"reason,omitempty"` // Why the run aborted, if it did
+}
+
+// Session pairs a completed protocol run with the channel built on its key
+type Session struct {
+	ID        string
+	Protocol  *BB84Protocol
+	Channel   *SecureChannel
+	Result    *ProtocolResult
+	Imported  bool // Key was imported rather than produced by a protocol run
+	CreatedAt time.Time
+
+	mu          sync.Mutex
+	qberHistory []QBERSample
+	phaseLog    []PhaseTransition
+	audit       *sessionAuditLog
+}
+
+// recordQBER appends a protocol run's QBER to the session history
+func (s *Session) recordQBER(result *ProtocolResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.qberHistory = append(s.qberHistory, QBERSample{
+		Time:    time.Now().UTC(),
+		Qber:    result.Qber,
+		Aborted: result.Aborted,
+		Reason:  result.Reason,
+	})
+}
+
+// QBERHistory returns the QBER of every protocol run in this session, oldest first
+func (s *Session) QBERHistory() []QBERSample {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	history := make([]QBERSample, len(s.qberHistory))
+	copy(history, s.qberHistory)
+	return history
+}
+
+// maxTrackedEvictions bounds how many evicted IDs are remembered so that
+// clients get ErrSessionEvicted rather than ErrSessionNotFound
+const maxTrackedEvictions = 4096
+
+// SessionManager stores the active sessions by ID. When MaxSessions is
+// positive, storing a new session beyond the limit evicts the least recently
+// used one and wipes its key material. DuplicatePolicy decides what
+// happens when a key is initialized under an ID already in use. Broadcasts
+// delivers each stored session's new messages to its WebSocket clients, and
+// disconnects them when the session is replaced or evicted.
+type SessionManager struct {
+	MaxSessions     int
+	DuplicatePolicy DuplicatePolicy
+	Broadcasts      *BroadcastHub
+
+	mu       sync.Mutex
+	sessions map[string]*list.Element // Values are *Session
+	lru      *list.List               // Front is most recently used
+	evicted  map[string]struct{}
+}
+
+// NewSessionManager creates an empty SessionManager
+func NewSessionManager() *SessionManager {
+	return &SessionManager{
+		Broadcasts: NewBroadcastHub(),
+		sessions:   make(map[string]*list.Element),
+		lru:        list.New(),
+		evicted:    make(map[string]struct{}),
+	}
+}
+
+// Get returns the session with the given ID and marks it as recently used
+func (m *SessionManager) Get(id string) (*Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	elem, ok := m.sessions[id]
+	if !ok {
+		if _, gone := m.evicted[id]; gone {
+			return nil, ErrSessionEvicted
+		}
+		return nil, ErrSessionNotFound
+	}
+	m.lru.MoveToFront(elem)
+	return elem.Value.(*Session), nil
+}
+
+// Admit checks that a new key may be stored under id by the duplicate
+// policy, returning the existing session, if any, for it to build on
+func (m *SessionManager) Admit(id string) (*Session, error) {
+	previous, err := m.Get(id)
+	if err != nil {
+		return nil, nil // Nothing to conflict with, evicted or not
+	}
+	if m.DuplicatePolicy == DuplicateReject {
+		return nil, fmt.Errorf("%w: %q", ErrSessionExists, id)
+	}
+	return previous, nil
+}
+
+// Store stores a newly keyed session that Admit returned previous for,
+// appending its key to previous under DuplicateAppend and replacing
+// previous otherwise. It reports whether the key was appended.
+func (m *SessionManager) Store(s, previous *Session) (appended bool, err error) {
+	if previous != nil && m.DuplicatePolicy == DuplicateAppend {
+		if err := s.extend(previous); err != nil {
+			return false, err
+		}
+		appended = true
+	}
+	m.Put(s)
+	return appended, nil
+}
+
+// Put stores a session, replacing any existing session with the same ID.
+// It reports whether a session was replaced.
+func (m *SessionManager) Put(s *Session) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.evicted, s.ID)
+	id := s.ID
+	s.Channel.notifyAppends(func(msg Message) { m.Broadcasts.Publish(id, msg) })
+	if elem, ok := m.sessions[s.ID]; ok {
+		// A reset gives the session a new channel, whose clients must start
+		// over; an appended key keeps the channel and its clients
+		if previous := elem.Value.(*Session); previous.Channel != s.Channel {
+			previous.Channel.notifyAppends(nil)
+			m.Broadcasts.Shutdown(s.ID)
+		}
+		elem.Value = s
+		m.lru.MoveToFront(elem)
+		return true
+	}
+
+	m.sessions[s.ID] = m.lru.PushFront(s)
+	for m.MaxSessions > 0 && m.lru.Len() > m.MaxSessions {
+		m.evictOldest()
+	}
+	return false
+}
+
+// evictOldest removes the least recently used session and zeroes its key.
+// The caller must hold m.mu.
+func (m *SessionManager) evictOldest() {
+	elem := m.lru.Back()
+	s := m.lru.Remove(elem).(*Session)
+	delete(m.sessions, s.ID)
+	if len(m.evicted) < maxTrackedEvictions {
+		m.evicted[s.ID] = struct{}{}
+	}
+	s.Channel.notifyAppends(nil)
+	m.Broadcasts.Shutdown(s.ID)
+	s.wipe()
+	log.Printf("sessions: evicted %q (limit %d)", s.ID, m.MaxSessions)
+}
+
+// wipe zeroes the session's key material so an evicted session cannot be
+// used even by a caller still holding a reference to it
+func (s *Session) wipe() {
+	if s.Channel != nil {
+		s.Channel.wipe()
+	}
+	if s.Protocol != nil {
+		clear(s.Protocol.SharedKey)
+		clear(s.Protocol.siftedKey)
+	}
+}
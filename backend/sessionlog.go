@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxSessionLogEntries bounds the phase transitions kept per session; the
+// oldest are dropped first
+const maxSessionLogEntries = 256
+
+// PhaseTransition records a protocol run moving between phases, with the
+// metrics the run had reached when it did
+type PhaseTransition struct {
+	Time       time.Time   `json:"time"`
+	From       Phase       `json:"from"`
+	To         Phase       `json:"to"`
+	RawBits    int         `json:"rawBits"`
+	SiftedBits int         `json:"siftedBits"`
+	Qber       float64     `json:"qber"`
+	SecureBits int         `json:"secureBits"`
+	Aborted    bool        `json:"aborted,omitempty"`
+	Reason     AbortReason `json:"reason,omitempty"`
+}
+
+// recordTransition logs the move from phase from to the current phase. The
+// result, once post-processing has produced one, supplies the later metrics.
+func (bb84 *BB84Protocol) recordTransition(from Phase, result *ProtocolResult) {
+	transition := PhaseTransition{
+		Time:       time.Now().UTC(),
+		From:       from,
+		To:         bb84.phase,
+		RawBits:    bb84.NumberOfBits,
+		SiftedBits: len(bb84.SharedKey),
+	}
+	if result != nil {
+		transition.SiftedBits = result.Distillation.SiftedBits
+		transition.Qber = result.Qber
+		transition.SecureBits = result.Distillation.SecureBits
+		transition.Aborted = result.Aborted
+		transition.Reason = result.Reason
+	}
+	bb84.transitions = append(bb84.transitions, transition)
+}
+
+// recordTransitions appends a protocol run's phase transitions to the
+// session log, dropping the oldest entries beyond maxSessionLogEntries
+func (s *Session) recordTransitions(transitions []PhaseTransition) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.phaseLog = append(s.phaseLog, transitions...)
+	if excess := len(s.phaseLog) - maxSessionLogEntries; excess > 0 {
+		s.phaseLog = append([]PhaseTransition(nil), s.phaseLog[excess:]...)
+	}
+}
+
+// PhaseLog returns the phase transitions of every protocol run in this
+// session, oldest first
+func (s *Session) PhaseLog() []PhaseTransition {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	log := make([]PhaseTransition, len(s.phaseLog))
+	copy(log, s.phaseLog)
+	return log
+}
+
+// Return the phase transitions of every protocol run in a session
+func sessionLogHandler(c *gin.Context) {
+	session, ok := sessionFromRequest(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"sessionId": session.ID, "transitions": session.PhaseLog()})
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// DefaultMinSiftingEfficiency is the MinSiftingEfficiency of
+// DefaultProtocolOptions
+const DefaultMinSiftingEfficiency = 0.4
+
+// minSiftingCheckQubits is the fewest detected qubits for which sifting
+// efficiency is checked. Below it the matching fraction of an honest BB84
+// run strays from one half too often for a threshold to mean anything; at
+// it, 0.4 is over six standard deviations away.
+const minSiftingCheckQubits = 1000
+
+// ErrLowSiftingEfficiency is returned when far fewer bases matched than the
+// protocol variant predicts
+var ErrLowSiftingEfficiency = errors.New("sifting efficiency too low")
+
+// siftingEfficiency returns the fraction of detected qubits that sifting
+// kept, scaled so BB84's ideal yield of one half reads 0.5 for every
+// variant, and how many qubits were detected
+func (bb84 *BB84Protocol) siftingEfficiency() (float64, int) {
+	detected := 0
+	for i := 0; i < bb84.NumberOfBits; i++ {
+		if bb84.detected(i) {
+			detected++
+		}
+	}
+	if detected == 0 {
+		return 0, 0
+	}
+	return float64(len(bb84.SharedKey)) / float64(detected) * 0.5 / bb84.siftingYield(), detected
+}
+
+// checkSiftingEfficiency fails a run whose bases matched too rarely, a sign
+// of a biased basis choice, a bug, or an attack on basis announcement that
+// would otherwise only show up as a small key
+func (bb84 *BB84Protocol) checkSiftingEfficiency() error {
+	efficiency, detected := bb84.siftingEfficiency()
+	if bb84.MinSiftingEfficiency <= 0 || detected < minSiftingCheckQubits || efficiency >= bb84.MinSiftingEfficiency {
+		return nil
+	}
+	return fmt.Errorf("%w: %.3f of %d detected qubits, minimum %.3f",
+		ErrLowSiftingEfficiency, efficiency, detected, bb84.MinSiftingEfficiency)
+}
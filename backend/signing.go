@@ -0,0 +1,95 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+)
+
+// signatureHeader carries the base64 Ed25519 signature of a signed response
+const signatureHeader = "X-Signature"
+
+// LoadSigningKey reads a PKCS#8 PEM-encoded Ed25519 private key
+func LoadSigningKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, errors.New("no PRIVATE KEY PEM block found")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("signing key is %T, want Ed25519", parsed)
+	}
+	return key, nil
+}
+
+// signedPayload returns the bytes covered by a response signature: the JSON
+// encoding of the body without the shared key, in the key style jsonNaming
+// gives a response of contentType. In production, where the key is never
+// returned, this is exactly the response body.
+func signedPayload(body gin.H, contentType string) ([]byte, error) {
+	unsigned := make(gin.H, len(body))
+	for k, v := range body {
+		if k != "sharedKey" {
+			unsigned[k] = v
+		}
+	}
+	payload, err := json.Marshal(unsigned)
+	if err != nil || jsonNamingStyle == NamingCamelCase || !namedContentType(contentType) {
+		return payload, err
+	}
+	return transformJSON(payload, camelToSnake)
+}
+
+// signResponse sets the signature header for body when a signing key is loaded
+func signResponse(c *gin.Context, body gin.H) {
+	if signingKey == nil {
+		return
+	}
+	// c.JSON sets application/json unless errorBody already chose problem+json
+	contentType := c.Writer.Header().Get("Content-Type")
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	payload, err := signedPayload(body, contentType)
+	if err != nil {
+		return
+	}
+	c.Header(signatureHeader, base64.StdEncoding.EncodeToString(ed25519.Sign(signingKey, payload)))
+}
+
+// Return the public key clients use to verify signed responses
+func pubkeyHandler(c *gin.Context) {
+	if signingKey == nil {
+		respondError(c, http.StatusNotFound, "Response signing is not configured")
+		return
+	}
+
+	public := signingKey.Public().(ed25519.PublicKey)
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "Failed to encode public key")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"algorithm": "Ed25519",
+		"publicKey": base64.StdEncoding.EncodeToString(public),
+		"pem":       string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+		"header":    signatureHeader,
+	})
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"math"
+)
+
+// targetBatchMargin oversizes each batch sent toward TargetSiftedBits so a
+// run rarely needs another, small batch to cover a sifting shortfall
+const targetBatchMargin = 1.1
+
+// ErrTargetUnreachable is returned when TargetSiftedBits cannot be sifted
+// within the raw bit limit
+var ErrTargetUnreachable = errors.New("sifted bit target unreachable")
+
+// targetBatchSize returns how many more qubits are expected to sift the bits
+// still missing from TargetSiftedBits
+func (bb84 *BB84Protocol) targetBatchSize() int {
+	missing := bb84.TargetSiftedBits - len(bb84.SharedKey)
+	return int(math.Ceil(float64(missing)/bb84.siftingYield()*targetBatchMargin)) + 1
+}
+
+// truncateToTarget keeps exactly TargetSiftedBits sifted bits and drops the
+// qubits sent after the one that completed the target, so NumberOfBits
+// reports the raw bits the key actually consumed
+func (bb84 *BB84Protocol) truncateToTarget() {
+	target := bb84.TargetSiftedBits
+	n := bb84.siftedIndices[target-1] + 1
+
+	bb84.NumberOfBits = n
+	bb84.Alice.bits = bb84.Alice.bits[:n]
+	bb84.Alice.bases = bb84.Alice.bases[:n]
+	bb84.Bob.bases = bb84.Bob.bases[:n]
+	bb84.Bob.measuredBits = bb84.Bob.measuredBits[:n]
+	if bb84.Bob.missed != nil {
+		bb84.Bob.missed = bb84.Bob.missed[:n]
+	}
+	bb84.QuantumChannel = bb84.QuantumChannel[:n]
+
+	bb84.SharedKey = bb84.SharedKey[:target]
+	bb84.siftedIndices = bb84.siftedIndices[:target]
+	bb84.bobSiftedKey = bb84.bobSiftedKey[:target]
+	bb84.siftedKey = bb84.SharedKey
+}
@@ -0,0 +1,56 @@
+package main
+
+import "context"
+
+// TestVector is a fully specified BB84 run that other implementations can
+// reproduce and compare against
+type TestVector struct {
+	Bits       int     `json:"bits"`
+	Seed       int64   `json:"seed"`
+	AliceBits  []int   `json:"aliceBits"`
+	AliceBases []Basis `json:"aliceBases"`
+	BobBases   []Basis `json:"bobBases"`
+	SiftedKey  []int   `json:"siftedKey"`
+	Qber       float64 `json:"qber"`
+	FinalKey   []int   `json:"finalKey,omitempty"` // Empty when the run aborted
+}
+
+// testVectorOptions pins the post-processing of test vectors so they stay
+// the same whatever the defaults or server configuration: a contiguous QBER
+// sample and the seedless SHA-256 extractor, which is also cheap on long keys
+func testVectorOptions() ProtocolOptions {
+	return ProtocolOptions{
+		QBERSampleFraction: 0.1,
+		QBERThreshold:      0.11,
+		Extractor:          ExtractorSHA256,
+	}
+}
+
+// GenerateTestVector runs the protocol with a seeded RandSource and returns
+// its inputs, the expected sifted key and the key distilled from it by
+// testVectorOptions
+func GenerateTestVector(bits int, seed int64) TestVector {
+	bb84 := NewBB84Protocol(bits)
+	bb84.ProtocolOptions = testVectorOptions()
+	bb84.Rand = NewSeededRandSource(seed)
+
+	// A seeded source never fails, so the quantum phase always completes
+	ctx := context.Background()
+	_ = bb84.RunUntilSifted(ctx)
+	vector := TestVector{
+		Bits:       bits,
+		Seed:       seed,
+		AliceBits:  bb84.Alice.bits,
+		AliceBases: bb84.Alice.bases,
+		BobBases:   bb84.Bob.bases,
+		SiftedKey:  append([]int(nil), bb84.siftedKey...),
+	}
+
+	if result, err := bb84.Resume(ctx); err == nil {
+		vector.Qber = result.Qber
+		if !result.Aborted {
+			vector.FinalKey = bb84.SharedKey
+		}
+	}
+	return vector
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var updateVectors = flag.Bool("update-vectors", false, "rewrite testdata/vector-*.json from GenerateTestVector")
+
+// canonicalVectors are the published test vectors, kept in testdata
+var canonicalVectors = []struct {
+	bits int
+	seed int64
+}{
+	{16, 1},
+	{64, 42},
+	{256, 2024},
+}
+
+// TestCanonicalVectors checks that GenerateTestVector reproduces every
+// published vector byte for byte
+func TestCanonicalVectors(t *testing.T) {
+	for _, v := range canonicalVectors {
+		name := filepath.Join("testdata", fmt.Sprintf("vector-%d-%d.json", v.bits, v.seed))
+		got, err := json.Marshal(GenerateTestVector(v.bits, v.seed))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, '\n')
+
+		if *updateVectors {
+			if err := os.WriteFile(name, got, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s: GenerateTestVector(%d, %d) differs from the published vector:\n%s", name, v.bits, v.seed, got)
+		}
+	}
+}
+
+// TestVectorIsConsistent checks a vector against its own definition: the
+// sifted key is Alice's bits where the bases matched
+func TestVectorIsConsistent(t *testing.T) {
+	v := GenerateTestVector(256, 2024)
+	var sifted []int
+	for i, basis := range v.AliceBases {
+		if basis == v.BobBases[i] {
+			sifted = append(sifted, v.AliceBits[i])
+		}
+	}
+	if fmt.Sprint(sifted) != fmt.Sprint(v.SiftedKey) {
+		t.Errorf("sifted key %v, want %v", v.SiftedKey, sifted)
+	}
+	if len(v.FinalKey) == 0 {
+		t.Error("256-bit vector distilled no final key")
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/csv"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// QubitTrace records what happened to a single transmitted qubit
+type QubitTrace struct {
+	Index      int   `json:"index"`
+	AliceBit   int   `json:"aliceBit"`
+	AliceBasis Basis `json:"aliceBasis"`
+	BobBasis   Basis `json:"bobBasis"`
+	Received   int   `json:"received"`
+	Measured   int   `json:"measured"` // Bob's recorded outcome, including any injected errors
+	Matched    bool  `json:"matched"`
+	Detected   bool  `json:"detected"`
+	InKey      bool  `json:"inKey"` // Kept after QBER sampling, i.e. fed to privacy amplification
+}
+
+// ChannelTrace returns the per-qubit trace of the last protocol run
+func (bb84 *BB84Protocol) ChannelTrace() []QubitTrace {
+	inKey := make(map[int]bool, len(bb84.siftedIndices))
+	for _, idx := range bb84.siftedIndices[bb84.sampleSize:] {
+		inKey[idx] = true
+	}
+
+	trace := make([]QubitTrace, len(bb84.QuantumChannel))
+	for i := range trace {
+		trace[i] = QubitTrace{
+			Index:      i,
+			AliceBit:   bb84.Alice.bits[i],
+			AliceBasis: bb84.Alice.bases[i],
+			BobBasis:   bb84.Bob.bases[i],
+			Received:   bb84.QuantumChannel[i],
+			Measured:   bb84.Bob.measuredBits[i],
+			Matched:    bb84.Alice.bases[i] == bb84.Bob.bases[i],
+			Detected:   bb84.detected(i),
+			InKey:      inKey[i],
+		}
+	}
+	return trace
+}
+
+// Export the per-qubit channel trace as CSV
+func traceCSVHandler(c *gin.Context) {
+	session, ok := sessionFromRequest(c)
+	if !ok {
+		return
+	}
+
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", `attachment; filename="trace.csv"`)
+	c.Status(http.StatusOK)
+
+	w := csv.NewWriter(c.Writer)
+	w.Write([]string{"index", "alice_bit", "alice_basis", "bob_basis", "received", "matched", "in_key", "measured", "detected"})
+	for _, q := range session.Protocol.ChannelTrace() {
+		w.Write([]string{
+			strconv.Itoa(q.Index),
+			strconv.Itoa(q.AliceBit),
+			q.AliceBasis.String(),
+			q.BobBasis.String(),
+			strconv.Itoa(q.Received),
+			strconv.FormatBool(q.Matched),
+			strconv.FormatBool(q.InKey),
+			strconv.Itoa(q.Measured),
+			strconv.FormatBool(q.Detected),
+		})
+	}
+	w.Flush()
+}
+
+// KeyDifference locates a sifted bit where Alice's and Bob's values disagree
+type KeyDifference struct {
+	SiftedIndex int `json:"siftedIndex"`
+	QubitIndex  int `json:"qubitIndex"`
+}
+
+// KeyDiff returns every sifted position where Bob's key bit differs from
+// Alice's, before any error correction
+func (bb84 *BB84Protocol) KeyDiff() []KeyDifference {
+	diffs := make([]KeyDifference, 0)
+	for i, idx := range bb84.siftedIndices {
+		if bb84.siftedKey[i] != bb84.bobSiftedKey[i] {
+			diffs = append(diffs, KeyDifference{SiftedIndex: i, QubitIndex: idx})
+		}
+	}
+	return diffs
+}
+
+// Return the positions where Alice's and Bob's sifted keys differ
+func keyDiffHandler(c *gin.Context) {
+	session, ok := sessionFromRequest(c)
+	if !ok {
+		return
+	}
+
+	diffs := session.Protocol.KeyDiff()
+	c.JSON(http.StatusOK, gin.H{
+		"sessionId":   session.ID,
+		"siftedBits":  len(session.Protocol.siftedIndices),
+		"differences": diffs,
+	})
+}
+
+// BobMeasurements returns a copy of Bob's raw measurement outcome for every
+// qubit, indexed like the transmitted qubits
+func (bb84 *BB84Protocol) BobMeasurements() []int {
+	return append([]int(nil), bb84.Bob.measuredBits...)
+}
+
+// QuantumChannelBits returns a copy of what arrived at Bob for every qubit,
+// before sifting and before any injected errors were applied
+func (bb84 *BB84Protocol) QuantumChannelBits() []int {
+	return append([]int(nil), bb84.QuantumChannel...)
+}
+
+// Return the raw quantum channel as an array and as a string of 0s and 1s
+func quantumChannelHandler(c *gin.Context) {
+	session, ok := sessionFromRequest(c)
+	if !ok {
+		return
+	}
+
+	bits := session.Protocol.QuantumChannelBits()
+	var s strings.Builder
+	s.Grow(len(bits))
+	for _, bit := range bits {
+		s.WriteByte('0' + byte(bit))
+	}
+	c.JSON(http.StatusOK, gin.H{"bits": bits, "bitString": s.String()})
+}
+
+// BasisStats summarizes Bob's outcomes measured in one basis
+type BasisStats struct {
+	Measured int `json:"measured"`
+	Ones     int `json:"ones"`
+	Matched  int `json:"matched"` // Measured in the same basis Alice prepared
+}
+
+// Return Bob's raw measurement outcomes and bases with per-basis statistics
+func bobMeasurementsHandler(c *gin.Context) {
+	session, ok := sessionFromRequest(c)
+	if !ok {
+		return
+	}
+
+	protocol := session.Protocol
+	measurements := protocol.BobMeasurements()
+	bases := make([]string, len(measurements))
+	stats := map[string]*BasisStats{ZBasis.String(): {}, XBasis.String(): {}}
+	for i, bit := range measurements {
+		basis := protocol.Bob.bases[i]
+		bases[i] = basis.String()
+
+		s := stats[bases[i]]
+		s.Measured++
+		s.Ones += bit
+		if basis == protocol.Alice.bases[i] {
+			s.Matched++
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"sessionId":    session.ID,
+		"measurements": measurements,
+		"bases":        bases,
+		"basisStats":   stats,
+	})
+}
+
+// KeyBasisTrace returns the basis Bob measured each key bit in, aligned with
+// the sifted key left after QBER sampling. That is SharedKey up to privacy
+// amplification, whose hash output no longer maps to individual qubits.
+func (bb84 *BB84Protocol) KeyBasisTrace() []Basis {
+	if bb84.sampleSize > len(bb84.siftedIndices) {
+		return []Basis{}
+	}
+	kept := bb84.siftedIndices[bb84.sampleSize:]
+	bases := make([]Basis, len(kept))
+	for i, idx := range kept {
+		bases[i] = bb84.Bob.bases[idx]
+	}
+	return bases
+}
+
+// Return the measurement basis behind each sifted key bit
+func keyBasesHandler(c *gin.Context) {
+	session, ok := sessionFromRequest(c)
+	if !ok {
+		return
+	}
+
+	trace := session.Protocol.KeyBasisTrace()
+	bases := make([]string, len(trace))
+	counts := map[string]int{ZBasis.String(): 0, XBasis.String(): 0}
+	for i, b := range trace {
+		bases[i] = b.String()
+		counts[bases[i]]++
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"sessionId": session.ID,
+		"bases":     bases,
+		"counts":    counts,
+	})
+}
+
+// MatchMask packs which qubits survived sifting into a bitmask, most
+// significant bit first: bit i is set when qubit i was kept. For BB84 these
+// are the positions where the bases matched.
+func (bb84 *BB84Protocol) MatchMask() []byte {
+	mask := make([]byte, (bb84.NumberOfBits+7)/8)
+	for _, idx := range bb84.siftedIndices {
+		mask[idx/8] |= 1 << uint(7-idx%8)
+	}
+	return mask
+}
+
+// UnpackMatchMask returns the qubit indices set in a mask of n qubits
+func UnpackMatchMask(mask []byte, n int) []int {
+	bits := bytesToKey(mask)
+	indices := make([]int, 0, n/2)
+	for i := 0; i < n && i < len(bits); i++ {
+		if bits[i] == 1 {
+			indices = append(indices, i)
+		}
+	}
+	return indices
+}
+
+// Return the sifting match vector as a base64 bitmask. Which positions
+// matched is announced publicly during sifting, so this is not secret.
+func matchMaskHandler(c *gin.Context) {
+	session, ok := sessionFromRequest(c)
+	if !ok {
+		return
+	}
+
+	protocol := session.Protocol
+	c.JSON(http.StatusOK, gin.H{
+		"qubits":  protocol.NumberOfBits,
+		"matched": len(protocol.siftedIndices),
+		"mask":    base64.StdEncoding.EncodeToString(protocol.MatchMask()),
+	})
+}
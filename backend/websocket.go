@@ -0,0 +1,293 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/websocket"
+)
+
+// subscriberBuffer is how many messages a WebSocket client may fall behind
+// before it is disconnected, rather than slowing down the session
+const subscriberBuffer = 64
+
+// BroadcastHub delivers each session's new messages to its WebSocket
+// clients. A session's broadcaster goroutine runs only while it has
+// subscribers: the first Subscribe starts it, and it stops when the last
+// subscription is closed or the session is reset or evicted, so a
+// long-running server keeps no goroutine for a session nobody watches.
+type BroadcastHub struct {
+	mu           sync.Mutex
+	broadcasters map[string]*broadcaster
+}
+
+// broadcaster fans out one session's messages to its subscribers
+type broadcaster struct {
+	in      chan Message
+	stop    chan struct{}              // Closed to stop the goroutine
+	stopped chan struct{}              // Closed once the goroutine has returned
+	subs    map[*Subscription]struct{} // Guarded by the hub's mu
+}
+
+// Subscription receives a session's messages from the moment it is created.
+// C is closed when the subscription is closed, when the subscriber falls
+// more than subscriberBuffer messages behind, and when the session is reset
+// or evicted.
+type Subscription struct {
+	C <-chan Message
+
+	c   chan Message
+	hub *BroadcastHub
+	id  string
+	b   *broadcaster
+}
+
+// NewBroadcastHub creates a hub with no broadcasters running
+func NewBroadcastHub() *BroadcastHub {
+	return &BroadcastHub{broadcasters: make(map[string]*broadcaster)}
+}
+
+// Subscribe adds a subscriber to the session's broadcaster, starting it if
+// this is the first
+func (h *BroadcastHub) Subscribe(sessionID string) *Subscription {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	b, ok := h.broadcasters[sessionID]
+	if !ok {
+		b = &broadcaster{
+			in:      make(chan Message, subscriberBuffer),
+			stop:    make(chan struct{}),
+			stopped: make(chan struct{}),
+			subs:    make(map[*Subscription]struct{}),
+		}
+		h.broadcasters[sessionID] = b
+		go h.run(sessionID, b)
+	}
+	c := make(chan Message, subscriberBuffer)
+	sub := &Subscription{C: c, c: c, hub: h, id: sessionID, b: b}
+	b.subs[sub] = struct{}{}
+	return sub
+}
+
+// Close unsubscribes, stopping the broadcaster if no subscribers remain. It
+// may be called more than once.
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	s.hub.removeLocked(s)
+}
+
+// Publish hands msg to the session's broadcaster, if it has one. It never
+// blocks, since it is called with the channel locked: when the broadcaster
+// has fallen a whole buffer behind, its subscribers are disconnected and
+// must catch up from GET /messages.
+func (h *BroadcastHub) Publish(sessionID string, msg Message) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	b, ok := h.broadcasters[sessionID]
+	if !ok {
+		return
+	}
+	select {
+	case b.in <- msg:
+	default:
+		log.Printf("websocket: broadcaster for session %q fell behind, disconnecting %d clients", sessionID, len(b.subs))
+		h.shutdownLocked(sessionID, b)
+	}
+}
+
+// Shutdown disconnects every subscriber of the session and stops its
+// broadcaster, for a session that was reset or evicted
+func (h *BroadcastHub) Shutdown(sessionID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if b, ok := h.broadcasters[sessionID]; ok {
+		h.shutdownLocked(sessionID, b)
+	}
+}
+
+// Broadcasters returns how many broadcaster goroutines are running
+func (h *BroadcastHub) Broadcasters() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.broadcasters)
+}
+
+// run delivers messages to the broadcaster's subscribers until it is stopped
+func (h *BroadcastHub) run(sessionID string, b *broadcaster) {
+	defer close(b.stopped)
+	for {
+		select {
+		case <-b.stop:
+			return
+		case msg := <-b.in:
+			h.mu.Lock()
+			for sub := range b.subs {
+				select {
+				case sub.c <- msg:
+				default:
+					h.removeLocked(sub)
+				}
+			}
+			h.mu.Unlock()
+		}
+	}
+}
+
+// removeLocked closes one subscription and stops its broadcaster once none
+// remain. The caller must hold h.mu.
+func (h *BroadcastHub) removeLocked(sub *Subscription) {
+	if _, ok := sub.b.subs[sub]; !ok {
+		return
+	}
+	delete(sub.b.subs, sub)
+	close(sub.c)
+	if len(sub.b.subs) == 0 {
+		h.shutdownLocked(sub.id, sub.b)
+	}
+}
+
+// shutdownLocked closes every subscription of b and stops it. The caller
+// must hold h.mu.
+func (h *BroadcastHub) shutdownLocked(sessionID string, b *broadcaster) {
+	for sub := range b.subs {
+		delete(b.subs, sub)
+		close(sub.c)
+	}
+	if h.broadcasters[sessionID] == b {
+		delete(h.broadcasters, sessionID)
+		close(b.stop)
+	}
+}
+
+// WriteMetrics appends the number of running broadcasters in the Prometheus
+// text format
+func (h *BroadcastHub) WriteMetrics(sb *strings.Builder) {
+	sb.WriteString("# HELP qchat_websocket_broadcasters Sessions with WebSocket clients connected.\n")
+	sb.WriteString("# TYPE qchat_websocket_broadcasters gauge\n")
+	fmt.Fprintf(sb, "qchat_websocket_broadcasters %d\n", h.Broadcasters())
+}
+
+// allowedOrigin reports whether a WebSocket handshake from origin is allowed.
+// Browsers do not apply CORS to WebSockets, so the server checks the CORS
+// origins itself; with none configured, as in development, any is allowed.
+func allowedOrigin(origins []string, origin *url.URL) bool {
+	if len(origins) == 0 || slices.Contains(origins, "*") {
+		return true
+	}
+	return origin != nil && slices.Contains(origins, origin.Scheme+"://"+origin.Host)
+}
+
+// Stream a session's new messages over a WebSocket as JSON text frames, one
+// message per frame. ?room= restricts the stream to one room as on GET
+// /messages, and ?after= first replays stored messages with a higher
+// sequence number, so a client can list the history and then connect
+// without missing anything. The connection is closed when the session is
+// reset or evicted, or when the client falls too far behind; clients should
+// reconnect with ?after= set to the last sequence number they received.
+func messagesWebSocketHandler(origins []string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		session, ok := sessionFromRequest(c)
+		if !ok {
+			return
+		}
+		room, inRoom := c.GetQuery("room")
+		if inRoom {
+			var err error
+			if room, err = normalizeRoom(room); err != nil {
+				respondError(c, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+		after := -1
+		if v, ok := c.GetQuery("after"); ok {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				respondError(c, http.StatusBadRequest, "after must be a sequence number")
+				return
+			}
+			after = n
+		}
+
+		server := websocket.Server{
+			Handshake: func(config *websocket.Config, r *http.Request) error {
+				var err error
+				if config.Origin, err = websocket.Origin(config, r); err == nil && !allowedOrigin(origins, config.Origin) {
+					return fmt.Errorf("origin %s is not allowed", config.Origin)
+				}
+				return nil
+			},
+			Handler: func(ws *websocket.Conn) {
+				defer ws.Close()
+				deliver := func(msg Message) bool {
+					return (!inRoom || msg.Room == room) && msg.Seq > after
+				}
+
+				// Subscribe before replaying so no message falls in between
+				sub := sessions.Broadcasts.Subscribe(session.ID)
+				defer sub.Close()
+				if after >= 0 {
+					for _, msg := range session.Channel.History() {
+						if deliver(msg) {
+							if err := sendMessageFrame(ws, msg); err != nil {
+								return
+							}
+							after = msg.Seq
+						}
+					}
+				}
+
+				// The client sends nothing; reading only detects that it left
+				left := make(chan struct{})
+				go func() {
+					io.Copy(io.Discard, ws)
+					close(left)
+				}()
+				for {
+					select {
+					case msg, ok := <-sub.C:
+						if !ok {
+							return
+						}
+						if deliver(msg) {
+							if err := sendMessageFrame(ws, msg); err != nil {
+								return
+							}
+							after = msg.Seq
+						}
+					case <-left:
+						return
+					}
+				}
+			},
+		}
+		server.ServeHTTP(c.Writer, c.Request)
+	}
+}
+
+// sendMessageFrame writes msg as one text frame, with the keys in the
+// configured JSON naming style
+func sendMessageFrame(ws *websocket.Conn, msg Message) error {
+	frame, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if jsonNamingStyle == NamingSnakeCase {
+		if frame, err = transformJSON(frame, camelToSnake); err != nil {
+			return err
+		}
+	}
+	return websocket.Message.Send(ws, string(frame))
+}
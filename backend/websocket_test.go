@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/websocket"
+)
+
+// waitForGoroutines fails the test unless the number of goroutines falls
+// back to at most n within a second
+func waitForGoroutines(t *testing.T, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > n {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<16)
+			t.Fatalf("%d goroutines running, want at most %d:\n%s", runtime.NumGoroutine(), n, buf[:runtime.Stack(buf, true)])
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// receive returns the next message on sub, failing the test after a second
+func receive(t *testing.T, sub *Subscription) (Message, bool) {
+	t.Helper()
+	select {
+	case msg, ok := <-sub.C:
+		return msg, ok
+	case <-time.After(time.Second):
+		t.Fatal("no message within a second")
+		return Message{}, false
+	}
+}
+
+// TestBroadcasterLifecycle checks that a session's broadcaster starts with
+// its first subscriber, delivers to every subscriber, and stops when the
+// last one leaves or the session is shut down
+func TestBroadcasterLifecycle(t *testing.T) {
+	baseline := runtime.NumGoroutine()
+	hub := NewBroadcastHub()
+
+	a := []*Subscription{hub.Subscribe("a"), hub.Subscribe("a"), hub.Subscribe("a")}
+	b := []*Subscription{hub.Subscribe("b"), hub.Subscribe("b")}
+	if n := hub.Broadcasters(); n != 2 {
+		t.Fatalf("%d broadcasters for two sessions", n)
+	}
+	hub.Publish("a", Message{Seq: 1})
+	hub.Publish("b", Message{Seq: 2})
+	for _, sub := range a {
+		if msg, ok := receive(t, sub); !ok || msg.Seq != 1 {
+			t.Errorf("session a subscriber got %+v, %v", msg, ok)
+		}
+	}
+	for _, sub := range b {
+		if msg, ok := receive(t, sub); !ok || msg.Seq != 2 {
+			t.Errorf("session b subscriber got %+v, %v", msg, ok)
+		}
+	}
+
+	// The broadcaster stops with its last subscriber, not before
+	stopped := a[0].b.stopped
+	a[0].Close()
+	a[1].Close()
+	a[1].Close() // Closing twice is harmless
+	select {
+	case <-stopped:
+		t.Fatal("broadcaster stopped with a subscriber left")
+	default:
+	}
+	a[2].Close()
+	<-stopped
+	if _, ok := <-a[2].C; ok {
+		t.Error("closed subscription still delivers")
+	}
+
+	// Shutting a session down disconnects its subscribers
+	hub.Shutdown("b")
+	for _, sub := range b {
+		if _, ok := receive(t, sub); ok {
+			t.Error("subscription survived its session's shutdown")
+		}
+		sub.Close()
+	}
+	hub.Publish("b", Message{Seq: 3}) // Nobody is listening; must not block
+	if n := hub.Broadcasters(); n != 0 {
+		t.Errorf("%d broadcasters left running", n)
+	}
+	waitForGoroutines(t, baseline)
+}
+
+// TestSlowSubscriberIsDisconnected checks that a subscriber that stops
+// reading is dropped instead of blocking the session
+func TestSlowSubscriberIsDisconnected(t *testing.T) {
+	hub := NewBroadcastHub()
+	sub := hub.Subscribe("a")
+	for i := 0; i < 4*subscriberBuffer; i++ {
+		hub.Publish("a", Message{Seq: i + 1})
+	}
+
+	received := 0
+	for {
+		if _, ok := receive(t, sub); !ok {
+			break
+		}
+		received++
+	}
+	if received > subscriberBuffer {
+		t.Errorf("a stalled subscriber was sent %d messages, buffer is %d", received, subscriberBuffer)
+	}
+	if n := hub.Broadcasters(); n != 0 {
+		t.Errorf("%d broadcasters left after dropping the only subscriber", n)
+	}
+}
+
+// TestBroadcastersUnderConcurrency subscribes, publishes, unsubscribes and
+// shuts sessions down from many goroutines at once and checks that no
+// broadcaster goroutine outlives them. Run it with -race.
+func TestBroadcastersUnderConcurrency(t *testing.T) {
+	baseline := runtime.NumGoroutine()
+	hub := NewBroadcastHub()
+	var wg sync.WaitGroup
+	for w := 0; w < 16; w++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(seed))
+			var subs []*Subscription
+			for i := 0; i < 500; i++ {
+				id := fmt.Sprint("session", r.Intn(4))
+				switch r.Intn(4) {
+				case 0:
+					subs = append(subs, hub.Subscribe(id))
+				case 1:
+					hub.Publish(id, Message{Seq: i})
+				case 2:
+					if len(subs) > 0 {
+						k := r.Intn(len(subs))
+						subs[k].Close()
+						subs = append(subs[:k], subs[k+1:]...)
+					}
+				default:
+					if r.Intn(8) == 0 {
+						hub.Shutdown(id)
+					}
+				}
+				// Drain without blocking, as a connected client would
+				for _, sub := range subs {
+					select {
+					case <-sub.C:
+					default:
+					}
+				}
+			}
+			for _, sub := range subs {
+				sub.Close()
+			}
+		}(int64(w))
+	}
+	wg.Wait()
+	if n := hub.Broadcasters(); n != 0 {
+		t.Errorf("%d broadcasters left after every subscriber closed", n)
+	}
+	waitForGoroutines(t, baseline)
+}
+
+// TestSessionResetStopsBroadcaster checks that a session's clients receive
+// its messages and are disconnected when it is reset or evicted, but not
+// when a key is appended to it
+func TestSessionResetStopsBroadcaster(t *testing.T) {
+	r := rand.New(rand.NewSource(17))
+	m := NewSessionManager()
+	m.MaxSessions = 2
+	first := &Session{ID: "a", Channel: randomChannel(t, r, 256)}
+	m.Put(first)
+
+	sub := m.Broadcasts.Subscribe("a")
+	if _, err := first.Channel.EncryptMessage("hello", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if msg, ok := receive(t, sub); !ok || msg.Sender != "alice" {
+		t.Fatalf("subscriber got %+v, %v", msg, ok)
+	}
+
+	// Storing the same channel again, as appending a key does, keeps clients
+	m.Put(&Session{ID: "a", Channel: first.Channel})
+	if n := m.Broadcasts.Broadcasters(); n != 1 {
+		t.Fatalf("appending a key stopped the broadcaster")
+	}
+
+	m.Put(&Session{ID: "a", Channel: randomChannel(t, r, 256)})
+	if _, ok := receive(t, sub); ok {
+		t.Error("subscriber survived the session reset")
+	}
+	if _, err := first.Channel.EncryptMessage("stale", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if n := m.Broadcasts.Broadcasters(); n != 0 {
+		t.Errorf("the replaced channel restarted a broadcaster")
+	}
+
+	evicted := m.Broadcasts.Subscribe("a")
+	m.Put(&Session{ID: "b", Channel: randomChannel(t, r, 256)})
+	m.Put(&Session{ID: "c", Channel: randomChannel(t, r, 256)})
+	if _, ok := receive(t, evicted); ok {
+		t.Error("subscriber survived the session's eviction")
+	}
+	if n := m.Broadcasts.Broadcasters(); n != 0 {
+		t.Errorf("%d broadcasters left after eviction", n)
+	}
+}
+
+// TestMessagesWebSocket connects to /messages/ws, receives a stored and a
+// new message, and is disconnected when the session is reset
+func TestMessagesWebSocket(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/messages/ws", messagesWebSocketHandler([]string{"http://allowed.example"}))
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	r := rand.New(rand.NewSource(18))
+	session := &Session{ID: "ws-test", Channel: randomChannel(t, r, 256)}
+	sessions.Put(session)
+	if _, err := session.Channel.EncryptMessage("stored", "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/messages/ws?sessionId=ws-test&after=0"
+	if _, err := websocket.Dial(url, "", "http://denied.example"); err == nil {
+		t.Error("handshake from a foreign origin succeeded")
+	}
+	ws, err := websocket.Dial(url, "", "http://allowed.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	ws.SetDeadline(time.Now().Add(2 * time.Second))
+
+	next := func() (Message, error) {
+		var frame string
+		if err := websocket.Message.Receive(ws, &frame); err != nil {
+			return Message{}, err
+		}
+		var msg Message
+		return msg, json.Unmarshal([]byte(frame), &msg)
+	}
+	if msg, err := next(); err != nil || msg.Seq != 1 {
+		t.Fatalf("first frame %+v, %v; want the stored message", msg, err)
+	}
+	if _, err := session.Channel.EncryptMessage("live", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if msg, err := next(); err != nil || msg.Seq != 2 || msg.Sender != "bob" {
+		t.Fatalf("second frame %+v, %v; want the new message", msg, err)
+	}
+
+	sessions.Put(&Session{ID: "ws-test", Channel: randomChannel(t, r, 256)})
+	if msg, err := next(); err == nil {
+		t.Errorf("connection stayed open after the session reset and sent %+v", msg)
+	}
+}
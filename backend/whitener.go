@@ -0,0 +1,88 @@
+package main
+
+import "crypto/sha256"
+
+// WhitenerType selects the post-processing applied to raw random bits before
+// the protocol uses them
+type WhitenerType int
+
+const (
+	WhitenerNone       WhitenerType = iota // Raw bits are used as drawn
+	WhitenerVonNeumann                     // First bit of each unequal pair
+	WhitenerSHA256Mix                      // SHA-256 of every 512 raw bits
+)
+
+func (w WhitenerType) String() string {
+	switch w {
+	case WhitenerVonNeumann:
+		return "von-neumann"
+	case WhitenerSHA256Mix:
+		return "sha256-mix"
+	default:
+		return "none"
+	}
+}
+
+// sha256MixInputBits is how many raw bits SHA256Mix hashes per digest
+const sha256MixInputBits = 512
+
+// NewWhitenedRandSource wraps src so its bits are debiased by typ. Von
+// Neumann whitening removes any bias of independent bits exactly, at the
+// cost of about four raw bits per output bit; SHA256Mix halves the rate but
+// also spreads correlations between neighbouring bits.
+func NewWhitenedRandSource(src RandSource, typ WhitenerType) RandSource {
+	switch typ {
+	case WhitenerVonNeumann:
+		return vonNeumannRandSource{src: src}
+	case WhitenerSHA256Mix:
+		return &sha256MixRandSource{src: src}
+	default:
+		return src
+	}
+}
+
+// vonNeumannRandSource draws pairs of bits and keeps the first of a pair
+// that differs, discarding 00 and 11
+type vonNeumannRandSource struct {
+	src RandSource
+}
+
+func (v vonNeumannRandSource) Bit() (int, error) {
+	for {
+		a, err := v.src.Bit()
+		if err != nil {
+			return 0, err
+		}
+		b, err := v.src.Bit()
+		if err != nil {
+			return 0, err
+		}
+		if a != b {
+			return a, nil
+		}
+	}
+}
+
+// sha256MixRandSource hashes blocks of raw bits and serves the digest bits
+type sha256MixRandSource struct {
+	src     RandSource
+	pending []int // Digest bits not yet served
+}
+
+func (s *sha256MixRandSource) Bit() (int, error) {
+	if len(s.pending) == 0 {
+		raw := make([]int, sha256MixInputBits)
+		for i := range raw {
+			bit, err := s.src.Bit()
+			if err != nil {
+				return 0, err
+			}
+			raw[i] = bit
+		}
+		digest := sha256.Sum256(convertKeyToBytes(raw))
+		s.pending = bytesToKey(digest[:])
+	}
+	bit := s.pending[0]
+	s.pending = s.pending[1:]
+	return bit, nil
+}
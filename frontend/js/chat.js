@@ -47,7 +47,7 @@ async function initializeProtocol() {
             headers: {
                 'Content-Type': 'application/json'
             },
-            body: JSON.stringify({ bits: 256 })
+            body: JSON.stringify({ bits: 8192 })
         });
 
         const data = await response.json();
@@ -69,14 +69,22 @@ function displayMessage(message, sent) {
     messagesContainer.scrollTop = messagesContainer.scrollHeight; // Auto-scroll to the latest message
 }
 
-async function decryptMessage(ciphertext) {
+async function decryptMessage(msg) {
     try {
         const decryptResponse = await fetch('http://localhost:8080/decrypt', {
             method: 'POST',
             headers: {
                 'Content-Type': 'application/json'
             },
-            body: JSON.stringify({ ciphertext: ciphertext })
+            body: JSON.stringify({
+                ciphertext: msg.ciphertext,
+                sender: msg.sender,
+                offset: msg.offset,
+                mac: msg.mac,
+                compressed: msg.compressed,
+                cascade: msg.cascade,
+                macAlgorithm: msg.macAlgorithm
+            })
         });
 
         const decryptData = await decryptResponse.json();
@@ -149,13 +157,17 @@ async function pollMessages() {
             for (let i = 0; i < data.messages.length; i++) {
                 const msg = data.messages[i];
                 let plaintext;
+
+                if (msg.kind === 'meta' || msg.supersededBy) {
+                    continue; // Metadata events carry no ciphertext; edited messages show their replacement
+                }
                 
                 if (msg.sender === currentUser) {
-                    plaintext = await decryptMessage(msg.ciphertext);
+                    plaintext = await decryptMessage(msg);
                     displayMessage(plaintext, true);
                 } else {
                     try {
-                        plaintext = await decryptMessage(msg.ciphertext);
+                        plaintext = await decryptMessage(msg);
                         displayMessage(plaintext, false);
                     } catch (error) {
                         console.error('Failed to decrypt message:', error);
@@ -167,13 +179,17 @@ async function pollMessages() {
             for (let i = lastMessageCount; i < data.messages.length; i++) {
                 const msg = data.messages[i];
                 let plaintext;
+
+                if (msg.kind === 'meta' || msg.supersededBy) {
+                    continue; // Metadata events carry no ciphertext; edited messages show their replacement
+                }
                 
                 if (msg.sender === currentUser) {
-                    plaintext = await decryptMessage(msg.ciphertext);
+                    plaintext = await decryptMessage(msg);
                     displayMessage(plaintext, true);
                 } else {
                     try {
-                        plaintext = await decryptMessage(msg.ciphertext);
+                        plaintext = await decryptMessage(msg);
                         displayMessage(plaintext, false);
                     } catch (error) {
                         console.error('Failed to decrypt message:', error);